@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type AuditEntry struct {
+	ID        int       `db:"id" json:"id"`
+	Time      time.Time `db:"created_at" json:"time"`
+	Principal string    `db:"principal" json:"principal"`
+	SourceIP  string    `db:"source_ip" json:"source_ip,omitempty"`
+	Method    string    `db:"method" json:"method,omitempty"`
+	Path      string    `db:"path" json:"path,omitempty"`
+	Name      string    `db:"name" json:"name,omitempty"`
+	RequestID string    `db:"request_id" json:"request_id,omitempty"`
+
+	// known only for requests which have written nothing, the rest is recorded before the response is sent
+	Status int       `db:"status" json:"status,omitempty"`
+	Diff   AuditDiff `db:"diff" json:"diff,omitempty"`
+}
+
+// AuditDiff is metadata change set stored as JSON array
+type AuditDiff []MetadataChange
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+const (
+	anonymousPrincipal = "anonymous"
+	schedulerPrincipal = "system:scheduler"
+)
+
+type auditScopeKey struct{}
+
+// auditScope describes mutating request, configs it touches are audited by every transaction of store
+type auditScope struct {
+	entry AuditEntry
+	names []string
+	store DatabaseStore
+
+	// entries committed so far
+	recorded []AuditEntry
+}
+
+// Scan performs custom-type conversion, diff is stored as JSON array
+func (d *AuditDiff) Scan(src interface{}) error {
+	switch t := src.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(t, d)
+	case string:
+		return json.Unmarshal([]byte(t), d)
+	default:
+		return fmt.Errorf("unexpected data type %t", t)
+	}
+}
+
+// Value performs custom-type conversion, serialize diff as JSON array
+func (d AuditDiff) Value() (driver.Value, error) {
+	if d == nil {
+		return "[]", nil
+	}
+	buf, err := json.Marshal([]MetadataChange(d))
+	return string(buf), err
+}
+
+// InsertAuditEntry appends entry to the audit log
+func (db *Database) InsertAuditEntry(entry *AuditEntry) error {
+	return recordAuditTx(db.writer, entry)
+}
+
+// recordAuditTx appends entry with secret values hidden to the audit log through database or transaction
+func recordAuditTx(e sqlx.Execer, entry *AuditEntry) error {
+	stmt := `
+	INSERT INTO audit_log (created_at, principal, source_ip, method, path, name, request_id, status, diff)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	entry.Diff = redactDiff(entry.Diff)
+	_, err := e.Exec(stmt, entry.Time.UTC(), entry.Principal, entry.SourceIP, entry.Method, entry.Path,
+		entry.Name, entry.RequestID, entry.Status, entry.Diff)
+	return err
+}
+
+// Audited returns view of the store whose transactions record changes of configs touched by scope
+// in the audit log, mutation fails when its entry cannot be recorded
+func (db *Database) Audited(scope *auditScope) DatabaseStore {
+	return &Database{DB: db.DB, writer: db.writer, audit: scope}
+}
+
+// snapshotTx reads state of touched configs at the beginning of transaction
+func (s *auditScope) snapshotTx(tx *sqlx.Tx) (map[string]*Config, error) {
+	before := make(map[string]*Config, len(s.names))
+	for _, name := range s.names {
+		cfg, err := getConfigTx(tx, name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		before[name] = cfg
+	}
+	return before, nil
+}
+
+// recordTx records one entry for every touched config transaction has changed, or a single one when it
+// has changed none and nothing has been recorded for the request yet, returns recorded entries
+func (s *auditScope) recordTx(tx *sqlx.Tx, before map[string]*Config) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for _, name := range s.names {
+		after, err := getConfigTx(tx, name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if !configChanged(before[name], after) {
+			continue
+		}
+
+		var metaBefore, metaAfter *Metadata
+		if before[name] != nil {
+			metaBefore = before[name].Metadata
+		}
+		if after != nil {
+			metaAfter = after.Metadata
+		}
+		diff, err := diffMetadata(metaBefore, metaAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := s.entry
+		entry.Time = time.Now().UTC()
+		entry.Name = name
+		entry.Diff = diff
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 && len(s.recorded) == 0 {
+		entry := s.entry
+		entry.Time = time.Now().UTC()
+		entries = append(entries, entry)
+	}
+
+	for idx := range entries {
+		if err := recordAuditTx(tx, &entries[idx]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// committed remembers entries of committed transaction
+func (s *auditScope) committed(entries []AuditEntry) {
+	if s != nil {
+		s.recorded = append(s.recorded, entries...)
+	}
+}
+
+// GetAuditEntries retrieves up to limit entries recorded at or after since, of config named name unless it is empty
+func (db *Database) GetAuditEntries(name string, since time.Time, limit int) (*[]AuditEntry, error) {
+	stmt := `
+	SELECT id, created_at, principal, source_ip, method, path, name, request_id, status, diff FROM audit_log
+		WHERE created_at >= ?`
+
+	args := []interface{}{since.UTC()}
+	if name != "" {
+		stmt += ` AND name = ?`
+		args = append(args, name)
+	}
+	stmt += ` ORDER BY id ASC LIMIT ?`
+	args = append(args, limit)
+
+	entries := []AuditEntry{}
+	if err := db.Select(&entries, stmt, args...); err != nil {
+		return nil, err
+	}
+	return &entries, nil
+}
+
+// auditMiddleware audits mutating requests, writes made through storeFor record their entries within
+// the same transaction, one for every config they have changed or a single one for requests changing
+// no config, successful requests which have written nothing are recorded once they are served
+func (srv *WebServer) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions || isDryRun(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// config of the route, other configs are touched by handlers once they know them
+		name := ""
+		if strings.HasPrefix(r.URL.Path, "/configs/") {
+			name = mux.Vars(r)["name"]
+		}
+
+		scope := &auditScope{entry: *srv.requestAuditEntry(r, name)}
+		scope.store = srv.store.Audited(scope)
+		r = r.WithContext(context.WithValue(r.Context(), auditScopeKey{}, scope))
+		srv.auditTouch(r, name)
+
+		next.ServeHTTP(w, r)
+
+		for idx := range scope.recorded {
+			srv.mirrorAudit(&scope.recorded[idx])
+		}
+		if len(scope.recorded) > 0 {
+			return
+		}
+
+		status := http.StatusOK
+		if info := requestInfoFrom(r); info != nil {
+			status = info.rec.code()
+		}
+		if status < 200 || status > 299 {
+			return
+		}
+
+		entry := scope.entry
+		entry.Time = time.Now().UTC()
+		entry.Status = status
+		if err := srv.store.InsertAuditEntry(&entry); err != nil {
+			srv.log.Error("Error recording audit entry", zap.String("request_id", entry.RequestID), zap.Error(err))
+			return
+		}
+		srv.mirrorAudit(&entry)
+	})
+}
+
+// storeFor returns store mutations of request go through, those of audited requests record their
+// audit entries within the same transaction
+func (srv *WebServer) storeFor(r *http.Request) DatabaseStore {
+	if scope, ok := r.Context().Value(auditScopeKey{}).(*auditScope); ok {
+		return scope.store
+	}
+	return srv.store
+}
+
+// auditTouch adds configs request is about to change to its audit scope
+func (srv *WebServer) auditTouch(r *http.Request, names ...string) {
+	scope, ok := r.Context().Value(auditScopeKey{}).(*auditScope)
+	if !ok {
+		return
+	}
+
+	for _, name := range names {
+		if name == "" || containsString(scope.names, name) {
+			continue
+		}
+		scope.names = append(scope.names, name)
+	}
+}
+
+// configChanged reports whether config has been created, removed or got new revision
+func configChanged(before, after *Config) bool {
+	if before == nil || after == nil {
+		return before != after
+	}
+	return before.ID != after.ID || before.Revision != after.Revision
+}
+
+// requestAuditEntry prepares entry describing request of principal and request id found in its context
+func (srv *WebServer) requestAuditEntry(r *http.Request, name string) *AuditEntry {
+	return &AuditEntry{
+		Time:      time.Now().UTC(),
+		Principal: subjectFrom(r),
+		SourceIP:  sourceIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Name:      name,
+		RequestID: requestIDFrom(r),
+	}
+}
+
+// mirrorAudit copies recorded entry to the log stream when asked to
+func (srv *WebServer) mirrorAudit(entry *AuditEntry) {
+	if !srv.auditLog {
+		return
+	}
+	srv.log.Info("Audit",
+		zap.String("principal", entry.Principal),
+		zap.String("source_ip", entry.SourceIP),
+		zap.String("method", entry.Method),
+		zap.String("path", entry.Path),
+		zap.String("name", entry.Name),
+		zap.String("request_id", entry.RequestID),
+		zap.Int("status", entry.Status),
+		zap.Any("diff", entry.Diff),
+	)
+}
+
+// auditGetHandler handles GET /audit?name=abc&since=2006-01-02T15:04:05Z
+func (srv *WebServer) auditGetHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since, RFC 3339 timestamp is expected: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultAuditLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxAuditLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := srv.store.GetAuditEntries(query.Get("name"), since, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeAudit decodes audit log response
+func decodeAudit(t *testing.T, res *http.Response) []AuditEntry {
+	t.Helper()
+
+	var entries []AuditEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	srv, _ := newTestServer(t, authEnabled)
+
+	_, adminKey, err := srv.createAPIKey(srv.store, "ops", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	_, readerKey, err := srv.createAPIKey(srv.store, "ci", false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := srv.store.InsertRoleBinding(&RoleBinding{Subject: "api_key:ci", Role: roleReader, Pattern: "*"}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"key":"one"}}`), apiKeyHeader, adminKey, requestIDHeader, "create-abc")
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if got := res.Header.Get(requestIDHeader); got != "create-abc" {
+		t.Errorf("expected request id create-abc to be echoed but got %q", got)
+	}
+
+	res = getResponse(t, srv, "PATCH", "/configs/abc", strings.NewReader(`{"metadata":{"key":"two"}}`), apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	generatedID := res.Header.Get(requestIDHeader)
+	if !requestIDPattern.MatchString(generatedID) {
+		t.Errorf("expected request id to be generated but got %q", generatedID)
+	}
+
+	// neither dry runs nor failed requests are recorded
+	res = getResponse(t, srv, "PATCH", "/configs/abc?dryRun=true", strings.NewReader(`{"metadata":{"key":"three"}}`), apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	res = getResponse(t, srv, "PATCH", "/configs/abc", strings.NewReader(`{"metadata":{"key":"three"}}`), apiKeyHeader, readerKey)
+	assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+	// unsafe request ids are replaced
+	res = getResponse(t, srv, "POST", "/configs/abc/rename", strings.NewReader(`{"name":"xyz"}`), apiKeyHeader, adminKey, requestIDHeader, "bad id\n")
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	renameID := res.Header.Get(requestIDHeader)
+	if renameID == "bad id\n" || !requestIDPattern.MatchString(renameID) {
+		t.Errorf("expected unsafe request id to be replaced but got %q", renameID)
+	}
+
+	res = getResponse(t, srv, "GET", "/audit", nil, apiKeyHeader, readerKey)
+	assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+	res = getResponse(t, srv, "GET", "/audit?name=abc", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	entries := decodeAudit(t, res)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries of abc but got %+v", entries)
+	}
+
+	created := entries[0]
+	if created.Principal != "api_key:ops" || created.Method != "POST" || created.RequestID != "create-abc" ||
+		created.SourceIP != "192.0.2.10" {
+		t.Errorf("unexpected entry %+v", created)
+	}
+	if len(created.Diff) != 1 || created.Diff[0].Op != changeAdd || created.Diff[0].Path != "key" {
+		t.Errorf("unexpected diff of created config %+v", created.Diff)
+	}
+
+	patched := entries[1]
+	if patched.RequestID != generatedID || len(patched.Diff) != 1 || patched.Diff[0].Op != changeReplace ||
+		patched.Diff[0].Old != "one" || patched.Diff[0].New != "two" {
+		t.Errorf("unexpected entry of patched config %+v", patched)
+	}
+
+	if entries[2].RequestID != renameID || len(entries[2].Diff) != 1 || entries[2].Diff[0].Op != changeRemove {
+		t.Errorf("unexpected entry of renamed config %+v", entries[2])
+	}
+
+	res = getResponse(t, srv, "GET", "/audit?name=xyz", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if entries := decodeAudit(t, res); len(entries) != 1 || entries[0].RequestID != renameID {
+		t.Errorf("expected rename to be recorded for xyz but got %+v", entries)
+	}
+
+	// requests changing no config are recorded once
+	res = getResponse(t, srv, "POST", "/admin/apikeys", strings.NewReader(`{"name":"other"}`), apiKeyHeader, adminKey, requestIDHeader, "new-key")
+	assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+	res = getResponse(t, srv, "GET", "/audit", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	entries = decodeAudit(t, res)
+	last := entries[len(entries)-1]
+	if len(entries) != 5 || last.RequestID != "new-key" || last.Path != "/admin/apikeys" || last.Name != "" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	res = getResponse(t, srv, "GET", "/audit?since="+future, nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if entries := decodeAudit(t, res); len(entries) != 0 {
+		t.Errorf("expected no entries since %s but got %+v", future, entries)
+	}
+
+	res = getResponse(t, srv, "GET", "/audit?since=yesterday", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusBadRequest)
+	res = getResponse(t, srv, "GET", "/audit?limit=0", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusBadRequest)
+}
+
+func TestAuditLogAppendOnly(t *testing.T) {
+	_, memStore := newTestServer(t, nil)
+
+	if err := memStore.InsertAuditEntry(&AuditEntry{Time: time.Now(), Principal: "jwt:alice", Name: "abc"}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err := memStore.writer.Exec(`UPDATE audit_log SET principal = 'jwt:mallory'`); err == nil {
+		t.Error("expected audit entries to be immutable")
+	}
+	if _, err := memStore.writer.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Error("expected audit entries to be irremovable")
+	}
+
+	entries, err := memStore.GetAuditEntries("abc", time.Time{}, defaultAuditLimit)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(*entries) != 1 || (*entries)[0].Principal != "jwt:alice" {
+		t.Errorf("unexpected entries %+v", *entries)
+	}
+}
+
+func TestAuditLogAtomic(t *testing.T) {
+	srv, memStore := newTestServer(t, nil)
+
+	if _, err := memStore.writer.Exec(`CREATE TRIGGER audit_log_full BEFORE INSERT ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is full');
+		END`); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// mutation is rolled back along with its entry
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"key":"one"}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusInternalServerError)
+
+	res = getResponse(t, srv, "GET", "/configs/abc", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusNotFound)
+
+	res = getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"http://example.com","secret":"s3cret"}`))
+	assertResponseCode(t, res.StatusCode, http.StatusInternalServerError)
+
+	res = getResponse(t, srv, "GET", "/webhooks", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	assertResponseBody(t, readBody(t, res), "[]")
+}
+
+func TestAuditLogScheduler(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"key":"one"}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	chg := &ScheduledChange{Name: "abc", Metadata: &Metadata{"key": "two"}, EffectiveAt: time.Now().Add(-time.Minute)}
+	if _, err := srv.store.InsertScheduledChange(chg); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	srv.applyScheduledChanges(time.Now())
+
+	entries, err := srv.store.GetAuditEntries("abc", time.Time{}, defaultAuditLimit)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(*entries) != 2 {
+		t.Fatalf("expected 2 entries but got %+v", *entries)
+	}
+
+	applied := (*entries)[1]
+	if applied.Principal != schedulerPrincipal || len(applied.Diff) != 1 || applied.Diff[0].New != "two" {
+		t.Errorf("unexpected entry of scheduled change %+v", applied)
+	}
+	if (*entries)[0].Principal != anonymousPrincipal {
+		t.Errorf("expected anonymous principal without authentication but got %q", (*entries)[0].Principal)
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type APIKey struct {
+	ID      int        `db:"id" json:"id"`
+	Name    string     `db:"name" json:"name"`
+	Prefix  string     `db:"prefix" json:"prefix"`
+	Hash    string     `db:"hash" json:"-"`
+	Admin   bool       `db:"admin" json:"admin"`
+	Created time.Time  `db:"created_at" json:"created_at"`
+	Revoked *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// Principal is authenticated caller of the request
+type Principal struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Admin bool   `json:"admin"`
+
+	// role bindings of principal, resolved once per request
+	grants []RoleBinding
+}
+
+// AuthPolicy holds authentication settings, nothing is enforced unless it is enabled
+type AuthPolicy struct {
+	Enabled    bool
+	Issuer     string
+	Audience   string
+	AdminScope string
+
+	// verification keys by key id, static key is stored under empty id
+	keys map[string]interface{}
+}
+
+const (
+	principalAPIKey = "api_key"
+	principalJWT    = "jwt"
+	principalCert   = "cert"
+)
+
+const (
+	apiKeyPrefix          = "fresh_"
+	defaultJWTAdminScope  = "fresh:admin"
+	apiKeyHeader          = "X-API-Key"
+	apiKeyColumns         = `id, name, prefix, hash, admin, created_at, revoked_at`
+	authenticateChallenge = `Bearer realm="fresh"`
+)
+
+type principalKey struct{}
+
+var errUnauthenticated = errors.New("credentials are missing or invalid")
+
+// newAuthPolicy reads authentication settings and verification keys from environment variables
+func newAuthPolicy() (*AuthPolicy, error) {
+	// misspelt setting must not leave the server open
+	enabled, err := getBoolOrFail("SERVE_AUTH_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &AuthPolicy{
+		Enabled:    enabled,
+		Issuer:     getStringOrDefault("SERVE_JWT_ISSUER", ""),
+		Audience:   getStringOrDefault("SERVE_JWT_AUDIENCE", ""),
+		AdminScope: getStringOrDefault("SERVE_JWT_ADMIN_SCOPE", defaultJWTAdminScope),
+		keys:       map[string]interface{}{},
+	}
+
+	if path := getStringOrDefault("SERVE_JWT_JWKS_FILE", ""); path != "" {
+		if err := p.loadJWKS(path); err != nil {
+			return nil, fmt.Errorf("unable to load JWKS file: %w", err)
+		}
+	}
+	if path := getStringOrDefault("SERVE_JWT_KEY_FILE", ""); path != "" {
+		if err := p.loadStaticKey(path); err != nil {
+			return nil, fmt.Errorf("unable to load JWT key file: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// loadStaticKey reads PEM encoded RSA or ECDSA public key, anything else is taken as HMAC secret
+func (p *AuthPolicy) loadStaticKey(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(buf), "-----BEGIN") {
+		secret := []byte(strings.TrimSpace(string(buf)))
+		if len(secret) < 32 {
+			return errors.New("HMAC secret must be at least 32 bytes long")
+		}
+		p.keys[""] = secret
+		return nil
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(buf); err == nil {
+		p.keys[""] = key
+		return nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(buf); err == nil {
+		p.keys[""] = key
+		return nil
+	}
+	return errors.New("PEM block is neither RSA nor ECDSA public key")
+}
+
+// loadJWKS reads RSA, EC and symmetric keys of JSON web key set
+func (p *AuthPolicy) loadJWKS(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(buf, &set); err != nil {
+		return err
+	}
+
+	for idx, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeJWKInt(k.N)
+			e, err2 := decodeJWKInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				return fmt.Errorf("key %d: invalid RSA parameters", idx)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return fmt.Errorf("key %d: unsupported curve %q", idx, k.Crv)
+			}
+			x, err1 := decodeJWKInt(k.X)
+			y, err2 := decodeJWKInt(k.Y)
+			if err1 != nil || err2 != nil || !curve.IsOnCurve(x, y) {
+				return fmt.Errorf("key %d: invalid EC parameters", idx)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) < 32 {
+				return fmt.Errorf("key %d: symmetric key must be at least 32 bytes long", idx)
+			}
+			key = secret
+		default:
+			return fmt.Errorf("key %d: unsupported key type %q", idx, k.Kty)
+		}
+		p.keys[k.Kid] = key
+	}
+
+	if len(p.keys) == 0 {
+		return errors.New("key set holds no signing keys")
+	}
+	return nil
+}
+
+// decodeJWKInt decodes base64url encoded big-endian integer
+func decodeJWKInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+// verificationKey picks key of token by its kid, algorithm has to match type of the key
+func (p *AuthPolicy) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := p.keys[kid]
+	if !ok && kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, rs := token.Method.(*jwt.SigningMethodRSA)
+		_, ps := token.Method.(*jwt.SigningMethodRSAPSS)
+		ok = rs || ps
+	case *ecdsa.PublicKey:
+		_, ok = token.Method.(*jwt.SigningMethodECDSA)
+	case []byte:
+		_, ok = token.Method.(*jwt.SigningMethodHMAC)
+	}
+	if !ok {
+		return nil, fmt.Errorf("algorithm %q does not match key", token.Method.Alg())
+	}
+	return key, nil
+}
+
+// verifyJWT validates bearer token signature, expiry, issuer and audience
+func (p *AuthPolicy) verifyJWT(raw string) (*Principal, error) {
+	if len(p.keys) == 0 {
+		return nil, errors.New("bearer tokens are not accepted")
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, p.verificationKey); err != nil {
+		return nil, err
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if p.Issuer != "" && !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, errors.New("token issuer is not accepted")
+	}
+	if p.Audience != "" && !claims.VerifyAudience(p.Audience, true) {
+		return nil, errors.New("token audience is not accepted")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	principal := &Principal{Name: sub, Kind: principalJWT}
+	if scope, ok := claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			if s == p.AdminScope {
+				principal.Admin = true
+			}
+		}
+	}
+	return principal, nil
+}
+
+// generateAPIKey creates random key, its lookup prefix and hash to be stored
+func generateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 38)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(buf[:6])
+	key = apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[6:])
+	return key, prefix, hashAPIKey(key), nil
+}
+
+// hashAPIKey hashes key for storage, keys are random enough for plain SHA-256
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKeyPrefix extracts lookup prefix of key
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, apiKeyPrefix)
+	if rest == key {
+		return "", false
+	}
+	prefix, _, ok := strings.Cut(rest, "_")
+	return prefix, ok && prefix != ""
+}
+
+// InsertAPIKey stores hashed api key
+func (db *Database) InsertAPIKey(key *APIKey) (int, error) {
+	stmt := `INSERT INTO api_keys (name, prefix, hash, admin, created_at) VALUES (?, ?, ?, ?, datetime('now'))`
+
+	result, err := db.execTx(stmt, key.Name, key.Prefix, key.Hash, key.Admin)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetAPIKeys retrieves every api key, revoked ones included
+func (db *Database) GetAPIKeys() (*[]APIKey, error) {
+	keys := []APIKey{}
+	if err := db.Select(&keys, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id ASC`); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// GetAPIKeyByPrefix retrieves active api key by its lookup prefix
+func (db *Database) GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	key := &APIKey{}
+	if err := db.Get(key, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = ? AND revoked_at IS NULL`, prefix); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes active api key, sql.ErrNoRows is returned if there is nothing to revoke
+func (db *Database) RevokeAPIKey(id int) error {
+	_, err := db.execTx(`UPDATE api_keys SET revoked_at = datetime('now') WHERE id = ? AND revoked_at IS NULL`, id)
+	return err
+}
+
+// createAPIKey generates new api key and stores it through store, the only time plain key is known
+func (srv *WebServer) createAPIKey(store DatabaseStore, name string, admin bool) (*APIKey, string, error) {
+	key, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	rec := &APIKey{Name: name, Prefix: prefix, Hash: hash, Admin: admin, Created: time.Now().UTC()}
+	if rec.ID, err = store.InsertAPIKey(rec); err != nil {
+		return nil, "", err
+	}
+	return rec, key, nil
+}
+
+// verifyAPIKey looks key up by its prefix and compares hashes
+func (srv *WebServer) verifyAPIKey(key string) (*Principal, error) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+
+	rec, err := srv.store.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errUnauthenticated
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(hashAPIKey(key))) != 1 {
+		return nil, errUnauthenticated
+	}
+	return &Principal{Name: rec.Name, Kind: principalAPIKey, Admin: rec.Admin}, nil
+}
+
+// authenticate resolves principal from X-API-Key header or bearer token, api keys are accepted as bearer tokens as well
+func (srv *WebServer) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return srv.verifyAPIKey(key)
+	}
+
+	// verified client certificate identifies caller presenting no other credentials
+	if r.Header.Get("Authorization") == "" {
+		if principal := certPrincipal(r); principal != nil {
+			return principal, nil
+		}
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errUnauthenticated
+	}
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return srv.verifyAPIKey(token)
+	}
+
+	principal, err := srv.auth.verifyJWT(token)
+	if err != nil {
+		srv.log.Debug("Bearer token rejected", zap.Error(err))
+		return nil, errUnauthenticated
+	}
+	return principal, nil
+}
+
+// authMiddleware rejects unauthenticated requests, probes stay open
+func (srv *WebServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.auth.Enabled || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := srv.authenticate(r)
+		if err != nil {
+			if errors.Is(err, errUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", authenticateChallenge)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if principal.grants, err = srv.grantsFor(principal); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if info := requestInfoFrom(r); info != nil {
+			info.principal = principal
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// principalFrom returns authenticated caller, nil when authentication is disabled
+func principalFrom(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// subjectFrom returns subject of request principal, anonymous without authentication
+func subjectFrom(r *http.Request) string {
+	if p := principalFrom(r); p != nil {
+		return p.Subject()
+	}
+	return anonymousPrincipal
+}
+
+// apiKeysPostHandler handles POST /admin/apikeys
+func (srv *WebServer) apiKeysPostHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string `json:"name"`
+		Admin bool   `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		writeValidationError(w, &ValidationError{Message: "api key is invalid", Fields: []FieldError{{Field: "name", Message: "is required"}}})
+		return
+	}
+
+	rec, key, err := srv.createAPIKey(srv.storeFor(r), req.Name, req.Admin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	resp := struct {
+		*APIKey
+		Key string `json:"key"`
+	}{rec, key}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiKeysGetAllHandler handles GET /admin/apikeys
+func (srv *WebServer) apiKeysGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := srv.store.GetAPIKeys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiKeysDeleteOneHandler handles DELETE /admin/apikeys/123
+func (srv *WebServer) apiKeysDeleteOneHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if err := srv.storeFor(r).RevokeAPIKey(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "active api key was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "api key has successfully been revoked")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestAuthAPIKeys(t *testing.T) {
+	srv, _ := newTestServer(t, authEnabled)
+
+	res := getResponse(t, srv, "GET", "/healthz", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	res = getResponse(t, srv, "GET", "/configs", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+	if res.Header.Get("WWW-Authenticate") == "" {
+		t.Error("expected authentication challenge")
+	}
+
+	res = getResponse(t, srv, "GET", "/configs", nil, apiKeyHeader, "fresh_000000000000_nope")
+	assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+	_, adminKey, err := srv.createAPIKey(srv.store, "ops", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	_, readerKey, err := srv.createAPIKey(srv.store, "ci", false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// tampered secret with valid prefix
+	res = getResponse(t, srv, "GET", "/configs", nil, apiKeyHeader, readerKey+"x")
+	assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+	res = getResponse(t, srv, "GET", "/configs", nil, apiKeyHeader, readerKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", "Bearer "+readerKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	res = getResponse(t, srv, "POST", "/admin/apikeys", strings.NewReader(`{"name":"other"}`), apiKeyHeader, readerKey)
+	assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+	res = getResponse(t, srv, "POST", "/admin/apikeys", strings.NewReader(`{"name":"other"}`), apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+	var created struct {
+		ID  int    `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	res = getResponse(t, srv, "GET", "/configs", nil, apiKeyHeader, created.Key)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	res = getResponse(t, srv, "GET", "/admin/apikeys", nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	body, _ := io.ReadAll(res.Body)
+	if strings.Contains(string(body), created.Key) || strings.Contains(string(body), hashAPIKey(created.Key)) {
+		t.Errorf("key material leaked in %s", body)
+	}
+
+	res = getResponse(t, srv, "DELETE", "/admin/apikeys/"+uintString(uint64(created.ID)), nil, apiKeyHeader, adminKey)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	res = getResponse(t, srv, "GET", "/configs", nil, apiKeyHeader, created.Key)
+	assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+}
+
+func TestAuthJWT(t *testing.T) {
+	dir := t.TempDir()
+	secret := "0123456789abcdef0123456789abcdef"
+
+	claims := func(sub string, exp time.Duration, scope string) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": sub, "exp": time.Now().Add(exp).Unix(), "iss": "issuer", "aud": "fresh"}
+		if scope != "" {
+			c["scope"] = scope
+		}
+		return c
+	}
+
+	t.Run("static key", func(t *testing.T) {
+		keyFile := filepath.Join(dir, "hmac.key")
+		if err := os.WriteFile(keyFile, []byte(secret), 0600); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		srv, _ := newTestServer(t, map[string]string{
+			"SERVE_AUTH_ENABLED": "true",
+			"SERVE_JWT_KEY_FILE": keyFile,
+			"SERVE_JWT_ISSUER":   "issuer",
+			"SERVE_JWT_AUDIENCE": "fresh",
+		})
+
+		sign := func(c jwt.MapClaims) string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			return "Bearer " + token
+		}
+
+		res := getResponse(t, srv, "GET", "/configs", nil, "Authorization", sign(claims("alice", time.Hour, "")))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = getResponse(t, srv, "GET", "/admin/apikeys", nil, "Authorization", sign(claims("alice", time.Hour, "")))
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = getResponse(t, srv, "GET", "/admin/apikeys", nil, "Authorization", sign(claims("alice", time.Hour, "read "+defaultJWTAdminScope)))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", sign(claims("alice", -time.Minute, "")))
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+		wrongAudience := claims("alice", time.Hour, "")
+		wrongAudience["aud"] = "other"
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", sign(wrongAudience))
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+		noExpiry := claims("alice", time.Hour, "")
+		delete(noExpiry, "exp")
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", sign(noExpiry))
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+		unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims("alice", time.Hour, "")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", "Bearer "+unsigned)
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+	})
+
+	t.Run("jwks", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		jwks := map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}}
+		buf, _ := json.Marshal(jwks)
+		jwksFile := filepath.Join(dir, "jwks.json")
+		if err := os.WriteFile(jwksFile, buf, 0600); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		srv, _ := newTestServer(t, map[string]string{"SERVE_AUTH_ENABLED": "true", "SERVE_JWT_JWKS_FILE": jwksFile})
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims("bob", time.Hour, ""))
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		res := getResponse(t, srv, "GET", "/configs", nil, "Authorization", "Bearer "+signed)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		token.Header["kid"] = "k2"
+		signed, _ = token.SignedString(key)
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", "Bearer "+signed)
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+
+		// HMAC signed with public modulus must not pass as RSA
+		confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("bob", time.Hour, ""))
+		confused.Header["kid"] = "k1"
+		signed, _ = confused.SignedString(key.N.Bytes())
+		res = getResponse(t, srv, "GET", "/configs", nil, "Authorization", "Bearer "+signed)
+		assertResponseCode(t, res.StatusCode, http.StatusUnauthorized)
+	})
+}
+
+func TestAuthDisabled(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	res := getResponse(t, srv, "GET", "/admin/apikeys", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+}
+
+func TestAuthInvalidSetting(t *testing.T) {
+	t.Setenv("SERVE_AUTH_ENABLED", "on")
+
+	if _, err := newAuthPolicy(); err == nil || !strings.Contains(err.Error(), "SERVE_AUTH_ENABLED") {
+		t.Errorf("expected unparsable SERVE_AUTH_ENABLED to be rejected but got %v", err)
+	}
+}
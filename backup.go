@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Backup struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created_at"`
+}
+
+const (
+	defaultBackupKeep = 7
+
+	backupPrefix     = "state-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Backup writes consistent snapshot of the database into path, path must not exist,
+// it runs on writer connection as readers are not allowed to create files
+func (db *Database) Backup(path string) error {
+	_, err := db.writer.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// backupDir returns directory snapshots are written to, next to the database unless configured
+func backupDir() string {
+	return getStringOrDefault("SERVE_BACKUP_DIR", filepath.Join(filepath.Dir(databasePath()), "backups"))
+}
+
+// createBackup writes new snapshot into dir and removes the oldest ones above keep
+func (srv *WebServer) createBackup(dir string, keep int) (*Backup, error) {
+	srv.backupMu.Lock()
+	defer srv.backupMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := backupPrefix + now.Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(dir, name)
+
+	// snapshot is renamed into place once complete, partial files never look like backups
+	tmp := path + ".tmp"
+	if err := srv.store.Backup(tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rotateBackups(dir, keep); err != nil {
+		return nil, err
+	}
+
+	return &Backup{Name: name, Path: path, Size: info.Size(), Created: now}, nil
+}
+
+// rotateBackups keeps only keep newest snapshots in dir, non-positive keep disables rotation
+func rotateBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// timestamped names sort chronologically
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), backupPrefix) && strings.HasSuffix(entry.Name(), backupSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// validateSnapshot checks that file is intact database this release is able to migrate
+func validateSnapshot(path string) error {
+	if !existDir(path) {
+		return fmt.Errorf("snapshot %q does not exist", path)
+	}
+
+	snap, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	var integrity string
+	if err := snap.Get(&integrity, `PRAGMA integrity_check`); err != nil {
+		return fmt.Errorf("snapshot is not readable: %w", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("snapshot failed integrity check: %s", integrity)
+	}
+
+	var tables int
+	if err := snap.Get(&tables, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'configs'`); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return fmt.Errorf("snapshot has no configs table")
+	}
+
+	var version int
+	if err := snap.Get(&version, `PRAGMA user_version`); err != nil {
+		return err
+	}
+	if version > len(schemaMigrations) {
+		return fmt.Errorf("snapshot schema version %d is newer than supported %d", version, len(schemaMigrations))
+	}
+
+	return nil
+}
+
+// restoreSnapshot replaces database at target with validated copy of snapshot, previous database files
+// are kept aside with returned suffix, server must not be running
+func restoreSnapshot(snapshot, target string) (string, error) {
+	if err := validateSnapshot(snapshot); err != nil {
+		return "", err
+	}
+
+	// copy next to target first so the swap itself is a rename
+	staged := target + ".restore"
+	if err := copyFile(snapshot, staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+
+	// journal files belong to the replaced database, they would corrupt the restored one
+	suffix := ".pre-restore-" + time.Now().UTC().Format(backupTimeFormat)
+	for _, path := range []string{target, target + "-wal", target + "-shm"} {
+		if !existDir(path) {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			os.Remove(staged)
+			return "", err
+		}
+	}
+
+	if err := os.Rename(staged, target); err != nil {
+		return "", err
+	}
+	return suffix, nil
+}
+
+// copyFile copies src into newly created dst and flushes it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// adminBackupPostHandler handles POST /admin/backup
+func (srv *WebServer) adminBackupPostHandler(w http.ResponseWriter, r *http.Request) {
+	backup, err := srv.createBackup(srv.backupDir, srv.backupKeep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(backup); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
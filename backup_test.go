@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminBackup(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		dir := t.TempDir()
+
+		os.Setenv("SERVE_PORT", "8080")
+		os.Setenv("SERVE_BACKUP_DIR", dir)
+		os.Setenv("SERVE_BACKUP_KEEP", "2")
+		defer os.Unsetenv("SERVE_PORT")
+		defer os.Unsetenv("SERVE_BACKUP_DIR")
+		defer os.Unsetenv("SERVE_BACKUP_KEEP")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			_, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"a": "1"}})
+			return err
+		}
+
+		var last Backup
+		backup := TestSubmitSequenceRequest{
+			method: http.MethodPost,
+			path:   "/admin/backup",
+			body:   nil,
+			verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+				assertResponseCode(t, res.Code, http.StatusCreated)
+				if err := json.Unmarshal(res.Body.Bytes(), &last); err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+			},
+		}
+
+		testPairs := []TestSubmitSequenceRequest{backup, backup, backup}
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+
+		files, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*"+backupSuffix))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(files) != 2 {
+			t.Errorf("expected %d backups after rotation but got %d", 2, len(files))
+		}
+
+		if err := validateSnapshot(last.Path); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+
+		target := filepath.Join(t.TempDir(), "state.db")
+		if _, err := restoreSnapshot(last.Path, target); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if err := validateSnapshot(target); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	})
+
+	t.Run("invalid snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "broken.db")
+		if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if err := validateSnapshot(path); err == nil {
+			t.Error("expected broken snapshot to be rejected")
+		}
+	})
+
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type BatchOperation struct {
+	Op       string      `json:"op"`
+	Name     string      `json:"name"`
+	Metadata *Metadata   `json:"metadata,omitempty"`
+	Schema   string      `json:"schema,omitempty"`
+	Extends  ConfigNames `json:"extends,omitempty"`
+	Labels   StringMap   `json:"labels,omitempty"`
+}
+
+type BatchResult struct {
+	Index  int              `json:"index"`
+	Op     string           `json:"op"`
+	Name   string           `json:"name"`
+	Status int              `json:"status"`
+	Error  string           `json:"error,omitempty"`
+	Fields []FieldError     `json:"fields,omitempty"`
+	Config *Config          `json:"config,omitempty"`
+	Diff   []MetadataChange `json:"diff,omitempty"`
+}
+
+type BatchResponse struct {
+	Committed bool          `json:"committed"`
+	DryRun    bool          `json:"dry_run,omitempty"`
+	Results   []BatchResult `json:"results"`
+}
+
+// failed reports whether any operation of batch has failed
+func (resp *BatchResponse) failed() bool {
+	for _, result := range resp.Results {
+		if result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// batch operations, upsert replaces metadata like PUT and PATCH of single config do,
+// merge applies metadata as JSON merge patch the way import does in its merge mode
+const (
+	batchOpCreate = "create"
+	batchOpUpsert = "upsert"
+	batchOpMerge  = "merge"
+	batchOpDelete = "delete"
+)
+
+const maxBatchOperations = 1000
+
+var (
+	// errBatchAborted stops atomic batch and rolls the whole transaction back
+	errBatchAborted = errors.New("batch has been aborted")
+
+	// errBatchDryRun rolls back batch which has been run only to preview its results
+	errBatchDryRun = errors.New("batch has been run dry")
+)
+
+// runBatch applies operations in a single transaction of store, in atomic mode first failure rolls everything
+// back, otherwise every failed operation is rolled back to its own savepoint and the rest is committed,
+// dry run goes through the same steps but never commits
+func (srv *WebServer) runBatch(store DatabaseStore, ops []BatchOperation, atomic, dryRun bool) (*BatchResponse, error) {
+	resp := &BatchResponse{DryRun: dryRun, Results: make([]BatchResult, 0, len(ops))}
+
+	err := store.Batch(func(tx StoreTx) error {
+		for idx, op := range ops {
+			if err := tx.Savepoint("batch_op"); err != nil {
+				return err
+			}
+
+			result, err := srv.applyBatchOperation(tx, op, dryRun)
+			if err != nil {
+				return err
+			}
+			result.Index = idx
+			resp.Results = append(resp.Results, *result)
+
+			if result.Error == "" {
+				if err := tx.Release("batch_op"); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if atomic {
+				return errBatchAborted
+			}
+			if err := tx.RollbackTo("batch_op"); err != nil {
+				return err
+			}
+			if err := tx.Release("batch_op"); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errBatchDryRun
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errBatchAborted), errors.Is(err, errBatchDryRun):
+		return resp, nil
+	case err != nil:
+		return nil, err
+	}
+
+	resp.Committed = true
+	return resp, nil
+}
+
+// applyBatchOperation validates and executes single operation, failures are reported through result,
+// with diff set result also lists metadata changes the operation makes
+func (srv *WebServer) applyBatchOperation(tx StoreTx, op BatchOperation, diff bool) (*BatchResult, error) {
+	result := &BatchResult{Op: op.Op, Name: op.Name}
+
+	fail := func(status int, err error) (*BatchResult, error) {
+		result.Status = status
+		result.Error = err.Error()
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			result.Error = verr.Message
+			result.Fields = verr.Fields
+		}
+		return result, nil
+	}
+
+	current, err := tx.GetConfigByName(op.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	exists := err == nil
+
+	cfg := &Config{Name: op.Name, Metadata: op.Metadata, Schema: op.Schema, Extends: op.Extends, Labels: op.Labels}
+
+	switch op.Op {
+	case batchOpCreate:
+		if exists {
+			return fail(http.StatusConflict, ErrConfigExists)
+		}
+	case batchOpUpsert:
+		if exists {
+			keepDeclarations(cfg, current)
+		}
+	case batchOpMerge:
+		if !exists {
+			return fail(http.StatusNotFound, errors.New("configuration item was not found"))
+		}
+		if cfg.Metadata, err = mergeMetadata(current.Metadata, op.Metadata); err != nil {
+			return nil, err
+		}
+		keepDeclarations(cfg, current)
+	case batchOpDelete:
+		if !exists {
+			return fail(http.StatusNotFound, errors.New("configuration item was not found"))
+		}
+		if err := tx.DeleteConfigByName(op.Name); err != nil {
+			if errors.Is(err, ErrConfigInUse) {
+				return fail(http.StatusConflict, err)
+			}
+			return nil, err
+		}
+		if diff {
+			if result.Diff, err = diffMetadata(current.Metadata, nil); err != nil {
+				return nil, err
+			}
+		}
+		result.Status = http.StatusOK
+		return result, nil
+	default:
+		return fail(http.StatusBadRequest, fmt.Errorf("unknown operation %q", op.Op))
+	}
+
+	if !exists {
+		if verr := validateConfigName(op.Name); verr != nil {
+			return fail(http.StatusUnprocessableEntity, verr)
+		}
+	}
+
+	verr, err := srv.validateConfigWith(tx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if verr != nil {
+		return fail(http.StatusUnprocessableEntity, verr)
+	}
+
+	if exists {
+		if err := tx.UpdateConfigByName(op.Name, cfg); err != nil {
+			return nil, err
+		}
+		result.Status = http.StatusOK
+	} else {
+		if cfg.ID, err = tx.InsertConfig(cfg); err != nil {
+			return nil, err
+		}
+		result.Status = http.StatusCreated
+	}
+
+	if diff {
+		var before *Metadata
+		if exists {
+			before = current.Metadata
+		}
+		if result.Diff, err = diffMetadata(before, cfg.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Config = cfg
+	return result, nil
+}
+
+// keepDeclarations carries schema, parents and labels over from current config unless cfg replaces them
+func keepDeclarations(cfg, current *Config) {
+	cfg.ID = current.ID
+	if cfg.Schema == "" {
+		cfg.Schema = current.Schema
+	}
+	if cfg.Extends == nil {
+		cfg.Extends = current.Extends
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = current.Labels
+	}
+}
+
+// configsBatchHandler handles POST /configs:batch
+func (srv *WebServer) configsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []BatchOperation `json:"operations"`
+	}
+	srv.metadata.limitBody(w, r, maxBatchOperations)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if len(req.Operations) == 0 {
+		http.Error(w, "operations are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("batch is limited to %d operations", maxBatchOperations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// batch is all or nothing as far as permissions go
+	for _, op := range req.Operations {
+		if !srv.authorize(w, r, op.Name, roleEditor) {
+			return
+		}
+		srv.auditTouch(r, op.Name)
+	}
+
+	// atomic unless explicitly disabled
+	atomic := r.URL.Query().Get("atomic") == "" || isTrue(r.URL.Query().Get("atomic"))
+
+	resp, err := srv.runBatch(srv.storeFor(r), req.Operations, atomic, isDryRun(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// results show which secrets change but never their values
+	for idx := range resp.Results {
+		resp.Results[idx].Config = redactedConfig(resp.Results[idx].Config)
+		resp.Results[idx].Diff = redactDiff(resp.Results[idx].Diff)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if atomic && resp.failed() {
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBatchConfigs(t *testing.T) {
+
+	initDB := func(t *testing.T) InitializerFunc {
+		return func(db *Database) error {
+			createTable(t, db)
+			_, err := db.InsertConfig(&Config{Name: "existing", Metadata: &Metadata{"a": "1", "b": "2"}})
+			return err
+		}
+	}
+
+	decode := func(t *testing.T, res *httptest.ResponseRecorder) BatchResponse {
+		t.Helper()
+		var resp BatchResponse
+		if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		return resp
+	}
+
+	ops := `{"operations":[
+		{"op":"create","name":"new-one","metadata":{"x":"1"}},
+		{"op":"merge","name":"existing","metadata":{"b":null,"c":"3"}},
+		{"op":"create","name":"Invalid Name","metadata":{}},
+		{"op":"upsert","name":"new-two","metadata":{"y":"2"}}
+	]}`
+
+	t.Run("atomic", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs:batch",
+				body:   strings.NewReader(ops),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+
+					resp := decode(t, res)
+					if resp.Committed {
+						t.Error("expected batch to be rolled back")
+					}
+					if len(resp.Results) != 3 || resp.Results[2].Status != http.StatusUnprocessableEntity {
+						t.Errorf("unexpected results %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":1,"name":"existing","metadata":{"a":"1","b":"2"}}]`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+	t.Run("non-atomic", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs:batch?atomic=false",
+				body:   strings.NewReader(ops),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+
+					resp := decode(t, res)
+					if !resp.Committed {
+						t.Error("expected batch to be committed")
+					}
+
+					statuses := []int{http.StatusCreated, http.StatusOK, http.StatusUnprocessableEntity, http.StatusCreated}
+					if len(resp.Results) != len(statuses) {
+						t.Fatalf("expected %d results but got %d", len(statuses), len(resp.Results))
+					}
+					for idx, want := range statuses {
+						if resp.Results[idx].Status != want {
+							t.Errorf("expected status %d for operation %d but got %d", want, idx, resp.Results[idx].Status)
+						}
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var cfgs []Config
+					if err := json.Unmarshal(res.Body.Bytes(), &cfgs); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if len(cfgs) != 3 {
+						t.Fatalf("expected %d configs but got %d", 3, len(cfgs))
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/existing",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"existing","metadata":{"a":"1","c":"3"}}`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, initDB(t))
+
+		// PATCH of single config replaces metadata, merging has its own name
+		res := getResponse(t, srv, http.MethodPost, "/configs:batch", strings.NewReader(`{"operations":[{"op":"patch","name":"existing","metadata":{}}]}`))
+		assertResponseCode(t, res.StatusCode, http.StatusConflict)
+
+		var resp BatchResponse
+		if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(resp.Results) != 1 || resp.Results[0].Status != http.StatusBadRequest {
+			t.Errorf("expected operation to be refused but got %+v", resp.Results)
+		}
+	})
+
+}
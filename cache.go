@@ -0,0 +1,229 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize = 1024
+	defaultCacheTTL  = 30
+)
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// CachedStore is DatabaseStore serving config reads from memory, every write through it invalidates
+// affected entries, writes made by other processes become visible once entries expire
+type CachedStore struct {
+	DatabaseStore
+	*cacheState
+}
+
+// cacheState is shared by the store and its audited views
+type cacheState struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	items   map[string]*list.Element
+	order   *list.List
+	configs *cacheEntry
+
+	// generation is bumped on every write, loads started before it are not cached
+	generation uint64
+
+	configStats CacheStats
+	listStats   CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	cfg     *Config
+	cfgs    []Config
+	expires time.Time
+}
+
+// NewCachedStore wraps store with LRU of up to size configs, entries live for ttl
+func NewCachedStore(store DatabaseStore, size int, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		DatabaseStore: store,
+		cacheState: &cacheState{
+			size:  size,
+			ttl:   ttl,
+			now:   time.Now,
+			items: map[string]*list.Element{},
+			order: list.New(),
+		},
+	}
+}
+
+// Audited returns audited view of the store sharing the same cache
+func (c *CachedStore) Audited(scope *auditScope) DatabaseStore {
+	return &CachedStore{DatabaseStore: c.DatabaseStore.Audited(scope), cacheState: c.cacheState}
+}
+
+// GetConfigByName retrieves Config by its name, callers must treat metadata of returned config as read-only
+func (c *CachedStore) GetConfigByName(name string) (*Config, error) {
+	c.mu.Lock()
+	if el, ok := c.items[name]; ok {
+		entry := el.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.order.MoveToFront(el)
+			c.configStats.Hits++
+			cfg := *entry.cfg
+			c.mu.Unlock()
+			return &cfg, nil
+		}
+		c.removeElement(el)
+	}
+	c.configStats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	cfg, err := c.DatabaseStore.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return cfg, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		stored := *cfg
+		c.addConfig(name, &stored)
+	}
+	return cfg, nil
+}
+
+// GetConfigs retrieves all configs, search goes through it as well
+func (c *CachedStore) GetConfigs() (*[]Config, error) {
+	c.mu.Lock()
+	if c.configs != nil && c.now().Before(c.configs.expires) {
+		c.listStats.Hits++
+		cfgs := append([]Config(nil), c.configs.cfgs...)
+		c.mu.Unlock()
+		return &cfgs, nil
+	}
+	c.configs = nil
+	c.listStats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	cfgs, err := c.DatabaseStore.GetConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.configs = &cacheEntry{cfgs: append([]Config(nil), *cfgs...), expires: c.now().Add(c.ttl)}
+	}
+	return cfgs, nil
+}
+
+// addConfig stores config as the most recently used one, the least recently used is evicted when full
+func (c *CachedStore) addConfig(name string, cfg *Config) {
+	if el, ok := c.items[name]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[name] = c.order.PushFront(&cacheEntry{key: name, cfg: cfg, expires: c.now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.configStats.Evictions++
+	}
+}
+
+// removeElement drops single entry
+func (c *CachedStore) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+// invalidate drops given configs along with the list, all configs are dropped when no name is given
+func (c *CachedStore) invalidate(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.configs = nil
+
+	if len(names) == 0 {
+		c.items = map[string]*list.Element{}
+		c.order.Init()
+		return
+	}
+	for _, name := range names {
+		if el, ok := c.items[name]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Stats returns hit and miss counters of single config and list lookups
+func (c *CachedStore) Stats() (configs, lists CacheStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	configs, lists = c.configStats, c.listStats
+	configs.Entries = c.order.Len()
+	if c.configs != nil {
+		lists.Entries = 1
+	}
+	return configs, lists
+}
+
+// InsertConfig inserts Config
+func (c *CachedStore) InsertConfig(cfg *Config) (int, error) {
+	defer c.invalidate(cfg.Name)
+	return c.DatabaseStore.InsertConfig(cfg)
+}
+
+// DeleteConfigByName removes Config by its name
+func (c *CachedStore) DeleteConfigByName(name string) error {
+	defer c.invalidate(name)
+	return c.DatabaseStore.DeleteConfigByName(name)
+}
+
+// UpdateConfigByName replaces Config by its name
+func (c *CachedStore) UpdateConfigByName(name string, cfg *Config) error {
+	defer c.invalidate(name)
+	return c.DatabaseStore.UpdateConfigByName(name, cfg)
+}
+
+// UpsertConfig inserts or replaces Config
+func (c *CachedStore) UpsertConfig(cfg *Config) (bool, error) {
+	defer c.invalidate(cfg.Name)
+	return c.DatabaseStore.UpsertConfig(cfg)
+}
+
+// RenameConfig changes Config name
+func (c *CachedStore) RenameConfig(name, newName string) error {
+	defer c.invalidate(name, newName)
+	return c.DatabaseStore.RenameConfig(name, newName)
+}
+
+// CloneConfig inserts copy of source Config
+func (c *CachedStore) CloneConfig(source, cfg *Config) (int, error) {
+	defer c.invalidate(cfg.Name)
+	return c.DatabaseStore.CloneConfig(source, cfg)
+}
+
+// ApplyScheduledChanges applies due changes, any config might have changed
+func (c *CachedStore) ApplyScheduledChanges(now time.Time, validate configValidator) (*[]ScheduledChange, error) {
+	defer c.invalidate()
+	return c.DatabaseStore.ApplyScheduledChanges(now, validate)
+}
+
+// Batch executes fn within single transaction, any config might have changed
+func (c *CachedStore) Batch(fn func(tx StoreTx) error) error {
+	defer c.invalidate()
+	return c.DatabaseStore.Batch(fn)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedStore(t *testing.T) {
+
+	newStore := func(t *testing.T, size int) *CachedStore {
+		t.Helper()
+		_, db := newTestServer(t, nil, func(db *Database) error {
+			for _, name := range []string{"one", "two", "three"} {
+				if _, err := db.InsertConfig(&Config{Name: name, Metadata: &Metadata{"v": "1"}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return NewCachedStore(db, size, time.Minute)
+	}
+
+	t.Run("hits and invalidation", func(t *testing.T) {
+		cache := newStore(t, 8)
+
+		for i := 0; i < 3; i++ {
+			if _, err := cache.GetConfigByName("one"); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+		if _, err := cache.GetConfigs(); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		configs, lists := cache.Stats()
+		if configs.Hits != 2 || configs.Misses != 1 || lists.Misses != 1 {
+			t.Errorf("unexpected stats %+v %+v", configs, lists)
+		}
+
+		if err := cache.UpdateConfigByName("one", &Config{Name: "one", Metadata: &Metadata{"v": "2"}}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		cfg, err := cache.GetConfigByName("one")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if (*cfg.Metadata)["v"] != "2" {
+			t.Errorf("expected updated metadata but got %v", *cfg.Metadata)
+		}
+
+		cfgs, err := cache.GetConfigs()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if (*(*cfgs)[0].Metadata)["v"] != "2" {
+			t.Errorf("expected list to be invalidated but got %v", *(*cfgs)[0].Metadata)
+		}
+	})
+
+	t.Run("eviction and expiry", func(t *testing.T) {
+		cache := newStore(t, 2)
+
+		now := time.Now()
+		cache.now = func() time.Time { return now }
+
+		for _, name := range []string{"one", "two", "three"} {
+			if _, err := cache.GetConfigByName(name); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+
+		configs, _ := cache.Stats()
+		if configs.Entries != 2 || configs.Evictions != 1 {
+			t.Errorf("unexpected stats %+v", configs)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if _, err := cache.GetConfigByName("three"); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		configs, _ = cache.Stats()
+		if configs.Misses != 4 {
+			t.Errorf("expected expired entry to be reloaded, stats %+v", configs)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		cache := newStore(t, 8)
+
+		srv, err := NewWebServer(cache)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			srv.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/configs/one", nil))
+		}
+
+		res := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assertResponseCode(t, res.Code, http.StatusOK)
+		if !strings.Contains(res.Body.String(), `fresh_cache_hits_total{lookup="config"} 1`) {
+			t.Errorf("unexpected metrics %s", res.Body.String())
+		}
+	})
+
+}
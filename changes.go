@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// changeRow is single change log entry, config holds JSON snapshot of config after the change,
+// or before it when config was deleted
+type changeRow struct {
+	Seq      uint64         `db:"seq"`
+	Type     string         `db:"type"`
+	Name     string         `db:"name"`
+	Env      string         `db:"env"`
+	Revision int            `db:"revision"`
+	Config   sql.NullString `db:"config"`
+	Created  time.Time      `db:"created_at"`
+}
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// recordChangeTx appends mutation of cfg to the change log within the same transaction
+func recordChangeTx(tx *sqlx.Tx, typ string, cfg *Config) error {
+	return insertChangeTx(tx, typ, "", cfg)
+}
+
+// recordOverlayTx appends mutation of env overlay to the change log, snapshot is config as seen in env
+func recordOverlayTx(tx *sqlx.Tx, typ string, name, env string) error {
+	cfg, err := getConfigTx(tx, name)
+	if err != nil {
+		return err
+	}
+
+	ovr, err := getOverlayTx(tx, name, env)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		if cfg, err = applyOverlay(cfg, ovr); err != nil {
+			return err
+		}
+	}
+
+	return insertChangeTx(tx, typ, env, cfg)
+}
+
+// insertChangeTx stores change log entry, env is empty unless overlay has changed
+func insertChangeTx(tx *sqlx.Tx, typ string, env string, cfg *Config) error {
+
+	// snapshot keeps secret values encrypted like the config itself
+	sealed, err := sealMetadata(cfg.Metadata)
+	if err != nil {
+		return err
+	}
+	copied := *cfg
+	copied.Metadata = sealed
+
+	snapshot, err := json.Marshal(&copied)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO changes (type, name, env, revision, config, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(stmt, typ, cfg.Name, env, cfg.Revision, string(snapshot), time.Now().UTC())
+	return err
+}
+
+// recordStateTx appends current state of config named name to the change log
+func recordStateTx(tx *sqlx.Tx, typ string, name string) error {
+	cfg, err := getConfigTx(tx, name)
+	if err != nil {
+		return err
+	}
+	return recordChangeTx(tx, typ, cfg)
+}
+
+// GetChanges retrieves up to limit changes recorded after since, oldest first
+func (db *Database) GetChanges(since uint64, limit int) (*[]ConfigEvent, error) {
+	stmt := `SELECT seq, type, name, env, revision, config, created_at FROM changes WHERE seq > ? ORDER BY seq ASC LIMIT ?`
+
+	rows := []changeRow{}
+	if err := db.Select(&rows, stmt, since, limit); err != nil {
+		return nil, err
+	}
+
+	events := make([]ConfigEvent, 0, len(rows))
+	for _, row := range rows {
+		event := ConfigEvent{Seq: row.Seq, Type: row.Type, Name: row.Name, Env: row.Env, Revision: row.Revision, Time: row.Created}
+		if row.Config.Valid {
+			cfg := &Config{}
+			if err := json.Unmarshal([]byte(row.Config.String), cfg); err != nil {
+				return nil, fmt.Errorf("change %d: %w", row.Seq, err)
+			}
+			if err := openMetadata(cfg.Metadata); err != nil {
+				return nil, fmt.Errorf("change %d: %w", row.Seq, err)
+			}
+			cfg.Revision = row.Revision
+			event.Config = cfg
+		}
+		events = append(events, event)
+	}
+
+	return &events, nil
+}
+
+// LastChangeSeq returns sequence number of the latest recorded change
+func (db *Database) LastChangeSeq() (uint64, error) {
+	var seq uint64
+	if err := db.Get(&seq, `SELECT COALESCE(MAX(seq), 0) FROM changes`); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// LastChangeSeqOf returns sequence number of the latest change recorded for config named name,
+// overlay changes included
+func (db *Database) LastChangeSeqOf(name string) (uint64, error) {
+	var seq uint64
+	if err := db.Get(&seq, `SELECT COALESCE(MAX(seq), 0) FROM changes WHERE name = ?`, name); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// GetChangeCursor returns position consumer has reached in the change log, sql.ErrNoRows if it has none
+func (db *Database) GetChangeCursor(consumer string) (uint64, error) {
+	var seq uint64
+	if err := db.Get(&seq, `SELECT seq FROM change_cursors WHERE consumer = ?`, consumer); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// AdvanceChangeCursor moves position of consumer in the change log forward, it never goes back
+func (db *Database) AdvanceChangeCursor(consumer string, seq uint64) error {
+	return advanceChangeCursor(db.writer, consumer, seq)
+}
+
+// ExpireChangeCursors forgets positions of consumers named with prefix which have not moved since before
+func (db *Database) ExpireChangeCursors(prefix string, before time.Time) (int, error) {
+	stmt := `DELETE FROM change_cursors WHERE substr(consumer, 1, ?) = ? AND updated_at < ?`
+
+	result, err := db.writer.Exec(stmt, len(prefix), prefix, before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// advanceChangeCursor moves position of consumer forward through database or transaction
+func advanceChangeCursor(e sqlx.Execer, consumer string, seq uint64) error {
+	stmt := `INSERT INTO change_cursors (consumer, seq, updated_at) VALUES (?, ?, datetime('now'))
+		ON CONFLICT(consumer) DO UPDATE SET seq = MAX(seq, excluded.seq), updated_at = excluded.updated_at`
+
+	_, err := e.Exec(stmt, consumer, seq)
+	return err
+}
+
+// changesGetHandler handles GET /changes?since=123
+func (srv *WebServer) changesGetHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since uint64
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := defaultChangesLimit
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxChangesLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxChangesLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	srv.setChangesIndex(w)
+
+	changes, err := srv.store.GetChanges(since, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// sequence numbers of changes to configs caller cannot read are left as gaps
+	visible := make([]ConfigEvent, 0, len(*changes))
+	for _, change := range *changes {
+		if srv.allowed(r, change.Name, roleReader) {
+			visible = append(visible, change)
+		}
+	}
+	changes = &visible
+
+	reveal := srv.revealable(r)
+	for idx := range *changes {
+		(*changes)[idx] = redactEvent((*changes)[idx], reveal)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(changes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
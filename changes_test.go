@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// decodeChanges decodes change log response into "seq:type:name" entries
+func decodeChanges(t *testing.T, res *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var changes []ConfigEvent
+	if err := json.Unmarshal(res.Body.Bytes(), &changes); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	got := []string{}
+	for _, c := range changes {
+		got = append(got, uintString(c.Seq)+":"+c.Type+":"+c.Name)
+	}
+	return got
+}
+
+func assertChanges(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected changes %v but got %v", want, got)
+	}
+}
+
+func TestChanges(t *testing.T) {
+
+	t.Run("mutations", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs",
+				body:   strings.NewReader(`{"name":"abc","metadata":{"key":"one"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodPatch,
+				path:   "/configs/abc",
+				body:   strings.NewReader(`{"metadata":{"key":"two"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/abc/rename",
+				body:   strings.NewReader(`{"name":"xyz"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/xyz",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/changes",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertChanges(t, decodeChanges(t, res), []string{
+						"1:created:abc", "2:updated:abc", "3:deleted:abc", "4:created:xyz", "5:deleted:xyz",
+					})
+
+					var changes []ConfigEvent
+					json.Unmarshal(res.Body.Bytes(), &changes)
+					if changes[1].Revision != 2 || (*changes[1].Config.Metadata)["key"] != "two" {
+						t.Errorf("unexpected update %+v", changes[1])
+					}
+					if changes[4].Config == nil || (*changes[4].Config.Metadata)["key"] != "two" {
+						t.Errorf("expected deletion to carry last state, got %+v", changes[4])
+					}
+					if got := res.Header().Get("X-Config-Index"); got != "5" {
+						t.Errorf("expected index 5 but got %q", got)
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/changes?since=2&limit=2",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertChanges(t, decodeChanges(t, res), []string{"3:deleted:abc", "4:created:xyz"})
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/changes?since=-1",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusBadRequest)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/changes?limit=0",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusBadRequest)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, func(db *Database) error {
+			createTable(t, db)
+			return nil
+		}, &testPairs)
+	})
+
+	t.Run("rolled back batch", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs:batch",
+				body:   strings.NewReader(`{"operations":[{"op":"create","name":"one","metadata":{}},{"op":"create","name":"Bad Name","metadata":{}}]}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/changes",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertChanges(t, decodeChanges(t, res), []string{})
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, func(db *Database) error {
+			createTable(t, db)
+			return nil
+		}, &testPairs)
+	})
+
+	t.Run("events continue change log", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			if _, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{}}); err != nil {
+				return err
+			}
+			return db.UpdateConfigByName("abc", &Config{Metadata: &Metadata{"key": "two"}})
+		})
+		if got := srv.events.Seq(); got != 2 {
+			t.Errorf("expected bus to continue at 2 but got %d", got)
+		}
+
+		sub, _, _ := srv.events.Subscribe(srv.events.Seq(), nil)
+		defer sub.Close()
+
+		res := getResponse(t, srv, "DELETE", "/configs/abc", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		event := <-sub.C
+		if event.Seq != 3 || event.Type != eventDeleted {
+			t.Errorf("expected deletion with seq 3 but got %+v", event)
+		}
+	})
+
+	t.Run("overlays", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			_, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"replicas": "1", "region": "eu"}})
+			return err
+		})
+
+		sub, _, _ := srv.events.Subscribe(srv.events.Seq(), nil)
+		defer sub.Close()
+
+		res := getResponse(t, srv, "PUT", "/configs/abc/overlays/prod", strings.NewReader(`{"metadata":{"replicas":"3"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+		res = getResponse(t, srv, "PUT", "/configs/abc/overlays/prod", strings.NewReader(`{"metadata":{"replicas":"5"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		res = getResponse(t, srv, "DELETE", "/configs/abc/overlays/prod", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		event := <-sub.C
+		if event.Seq != 2 || event.Type != eventOverlayCreated || event.Env != "prod" {
+			t.Errorf("expected overlay creation with seq 2 but got %+v", event)
+		}
+
+		res = getResponse(t, srv, "GET", "/changes?since=1", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		var changes []ConfigEvent
+		if err := json.NewDecoder(res.Body).Decode(&changes); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(changes) != 3 {
+			t.Fatalf("expected 3 overlay changes but got %+v", changes)
+		}
+
+		// snapshot is config as seen in env
+		for idx, want := range []struct{ typ, replicas string }{
+			{eventOverlayCreated, "3"}, {eventOverlayUpdated, "5"}, {eventOverlayDeleted, "1"},
+		} {
+			change := changes[idx]
+			if change.Type != want.typ || change.Env != "prod" || change.Config == nil {
+				t.Fatalf("expected %s of prod overlay but got %+v", want.typ, change)
+			}
+			md := *change.Config.Metadata
+			if md["replicas"] != want.replicas || md["region"] != "eu" {
+				t.Errorf("expected %s to carry merged metadata with %s replicas but got %v", want.typ, want.replicas, md)
+			}
+		}
+	})
+}
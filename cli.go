@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// runCommand executes command line subcommand against the database directly, returns process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "export":
+		return exportCommand(args)
+	case "import":
+		return importCommand(args)
+	case "restore":
+		return restoreCommand(args)
+	case "apikey":
+		return apiKeyCommand(args)
+	case "rotate-secrets":
+		return rotateSecretsCommand(args)
+	}
+
+	fmt.Printf("Error: unknown command %q, expected one of export, import, restore, apikey, rotate-secrets\n", name)
+	return 2
+}
+
+// newCommandServer prepares web-server struct for command line tools, it never listens
+func newCommandServer(store DatabaseStore) (*WebServer, error) {
+	metadata, err := newMetadataPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebServer{
+		log:             zap.NewNop(),
+		store:           store,
+		metadata:        metadata,
+		extendsMaxDepth: getIntOrDefault("SERVE_EXTENDS_MAX_DEPTH", defaultExtendsMaxDepth),
+		renderEnv:       parseRenderEnv(),
+	}, nil
+}
+
+// exportCommand handles `fresh export [-format jsonl] [-history] [-out file]`
+func exportCommand(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", formatJSONL, "output format: jsonl, json or yaml")
+	history := flags.Bool("history", false, "include revision history")
+	out := flags.String("out", "", "output file, stdout if empty")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := parseExchangeFormat(*format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+
+	db, closeDB, err := NewDatabaseStore()
+	if err != nil {
+		fmt.Println("Error: unable to initialize database:", err)
+		return 1
+	}
+	defer closeDB()
+
+	srv, err := newCommandServer(db)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := srv.exportConfigs(w, f, *history, nil, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: unable to export configs:", err)
+		return 1
+	}
+	return 0
+}
+
+// importCommand handles `fresh import [-format jsonl] [-mode merge] [-dry-run] [file]`, reads stdin without file
+func importCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", formatJSONL, "input format: jsonl, json or yaml")
+	mode := flags.String("mode", importModeMerge, "existing configs handling: merge, replace or skip")
+	dryRun := flags.Bool("dry-run", false, "validate and report without committing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := parseExchangeFormat(*format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	m, err := parseImportMode(*mode)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.NArg() > 0 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+
+	recs, err := decodeRecords(r, f)
+	if err != nil {
+		fmt.Println("Error: unable to read records:", err)
+		return 1
+	}
+
+	db, closeDB, err := NewDatabaseStore()
+	if err != nil {
+		fmt.Println("Error: unable to initialize database:", err)
+		return 1
+	}
+	defer closeDB()
+
+	srv, err := newCommandServer(db)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	// report every record as it goes
+	progress := func(result ImportResult) {
+		msg := fmt.Sprintf("line %d: %s", result.Line, result.Status)
+		if result.Name != "" {
+			msg += " " + result.Name
+		}
+		if result.Error != "" {
+			msg += ": " + result.Error
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+
+	resp, err := srv.importConfigs(srv.store, recs, m, *dryRun, progress)
+	if err != nil {
+		fmt.Println("Error: unable to import configs:", err)
+		return 1
+	}
+
+	fmt.Printf("created %d, updated %d, skipped %d, failed %d\n", resp.Created, resp.Updated, resp.Skipped, resp.Failed)
+	if resp.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// restoreCommand handles `fresh restore snapshot`, must be run while server is stopped
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Println("Error: snapshot file is required")
+		return 2
+	}
+
+	target := databasePath()
+	suffix, err := restoreSnapshot(flags.Arg(0), target)
+	if err != nil {
+		fmt.Println("Error: unable to restore snapshot:", err)
+		return 1
+	}
+
+	// bring restored structure up to date before server starts on it
+	_, closeDB, err := NewDatabaseStore()
+	if err != nil {
+		fmt.Println("Error: unable to open restored database:", err)
+		return 1
+	}
+	closeDB()
+
+	fmt.Printf("restored %s from %s, previous database kept with suffix %s\n", target, flags.Arg(0), suffix)
+	return 0
+}
+
+// apiKeyCommand handles `fresh apikey -name ci [-admin]`, prints the key which is not retrievable later
+func apiKeyCommand(args []string) int {
+	flags := flag.NewFlagSet("apikey", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the key owner")
+	admin := flags.Bool("admin", false, "grant admin privileges")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" {
+		fmt.Println("Error: key name is required")
+		return 2
+	}
+
+	db, closeDB, err := NewDatabaseStore()
+	if err != nil {
+		fmt.Println("Error: unable to initialize database:", err)
+		return 1
+	}
+	defer closeDB()
+
+	srv, err := newCommandServer(db)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	rec, key, err := srv.createAPIKey(srv.store, *name, *admin)
+	if err != nil {
+		fmt.Println("Error: unable to create api key:", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "created api key %d for %s\n", rec.ID, rec.Name)
+	fmt.Println(key)
+	return 0
+}
+
+// rotateSecretsCommand handles `fresh rotate-secrets`, values sealed with keys other than the first one
+// of the key file are encrypted again with it
+func rotateSecretsCommand(args []string) int {
+	flags := flag.NewFlagSet("rotate-secrets", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	db, closeDB, err := NewDatabaseStore()
+	if err != nil {
+		fmt.Println("Error: unable to initialize database:", err)
+		return 1
+	}
+	defer closeDB()
+
+	rotated, err := db.RotateSecrets()
+	if err != nil {
+		fmt.Println("Error: unable to rotate secrets:", err)
+		return 1
+	}
+
+	fmt.Printf("%d secret values have been re-encrypted\n", rotated)
+	return 0
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type DatabaseStore interface {
@@ -19,10 +22,81 @@ type DatabaseStore interface {
 	GetConfigs() (*[]Config, error)
 	DeleteConfigByName(name string) error
 	UpdateConfigByName(name string, cfg *Config) error
+	UpsertConfig(cfg *Config) (bool, error)
+	InsertScheduledChange(chg *ScheduledChange) (int, error)
+	GetScheduledChanges(name string) (*[]ScheduledChange, error)
+	GetScheduledChange(id int) (*ScheduledChange, error)
+	CancelScheduledChange(id int) error
+	ApplyScheduledChanges(now time.Time, validate configValidator) (*[]ScheduledChange, error)
+	InsertSchema(s *Schema) error
+	GetSchema(name string) (*Schema, error)
+	GetSchemas() (*[]Schema, error)
+	RenameConfig(name, newName string) error
+	GetConfigHistory(name string) (*[]ConfigRevision, error)
+	CloneConfig(source *Config, cfg *Config) (int, error)
+	GetConfigDependents(name string) (*[]string, error)
+	PutOverlay(ovr *Overlay) (bool, error)
+	GetOverlay(name, env string) (*Overlay, error)
+	GetOverlays(name, env string) (*[]Overlay, error)
+	DeleteOverlay(name, env string) error
+	GetOverlayHistory(name, env string) (*[]ConfigRevision, error)
+	Batch(fn func(tx StoreTx) error) error
+	Backup(path string) error
+	InsertWebhook(hook *Webhook) (int, error)
+	GetWebhooks() (*[]Webhook, error)
+	GetWebhook(id int) (*Webhook, error)
+	DeleteWebhook(id int) error
+	EnqueueDeliveries(seq uint64, dls []WebhookDelivery) error
+	GetDueDeliveries(now time.Time, limit int) (*[]WebhookDelivery, error)
+	UpdateDelivery(dl *WebhookDelivery) error
+	GetDeliveries(webhookID int, status string) (*[]WebhookDelivery, error)
+	RetryDelivery(id int, now time.Time) error
+	GetChanges(since uint64, limit int) (*[]ConfigEvent, error)
+	LastChangeSeq() (uint64, error)
+	LastChangeSeqOf(name string) (uint64, error)
+	GetChangeCursor(consumer string) (uint64, error)
+	AdvanceChangeCursor(consumer string, seq uint64) error
+	ExpireChangeCursors(prefix string, before time.Time) (int, error)
+	InsertAPIKey(key *APIKey) (int, error)
+	GetAPIKeys() (*[]APIKey, error)
+	GetAPIKeyByPrefix(prefix string) (*APIKey, error)
+	RevokeAPIKey(id int) error
+	InsertRoleBinding(b *RoleBinding) (int, error)
+	GetRoleBindings() (*[]RoleBinding, error)
+	DeleteRoleBinding(id int) error
+	RotateSecrets() (int, error)
+	InsertAuditEntry(entry *AuditEntry) error
+	GetAuditEntries(name string, since time.Time, limit int) (*[]AuditEntry, error)
+	Audited(scope *auditScope) DatabaseStore
 }
 
+// configReader is the read side shared by the store and its transactions
+type configReader interface {
+	GetConfigByName(name string) (*Config, error)
+	GetSchema(name string) (*Schema, error)
+}
+
+// configValidator checks config against policy and schema, reading related configs through reader
+type configValidator func(reader configReader, cfg *Config) (*ValidationError, error)
+
+// StoreTx groups config mutations into a single transaction
+type StoreTx interface {
+	configReader
+	InsertConfig(cfg *Config) (int, error)
+	UpdateConfigByName(name string, cfg *Config) error
+	DeleteConfigByName(name string) error
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Release(name string) error
+}
+
+// Database reads through embedded pool, writes go through single writer connection
 type Database struct {
 	*sqlx.DB
+	writer *sqlx.DB
+
+	// request whose mutations are audited, set on views created by Audited
+	audit *auditScope
 }
 
 type Metadata map[string]interface{}
@@ -31,9 +105,31 @@ type Config struct {
 	ID       int       `db:"id" json:"id"`
 	Name     string    `db:"name" json:"name"`
 	Metadata *Metadata `db:"metadata" json:"metadata"`
+	Schema   string    `db:"schema" json:"schema,omitempty"`
+	Revision int       `db:"revision" json:"-"`
 	Created  time.Time `db:"created_at" json:"-"`
+
+	// parents whose metadata is merged underneath this config, in order
+	Extends ConfigNames `db:"extends" json:"extends,omitempty"`
+
+	// selected by watches, webhooks and role bindings
+	Labels StringMap `db:"labels" json:"labels,omitempty"`
+
+	// provenance of cloned configs
+	ClonedFrom     string `db:"cloned_from" json:"cloned_from,omitempty"`
+	ClonedRevision int    `db:"cloned_revision" json:"cloned_revision,omitempty"`
 }
 
+type ConfigRevision struct {
+	Revision int         `db:"revision" json:"revision"`
+	Metadata *Metadata   `db:"metadata" json:"metadata"`
+	Schema   string      `db:"schema" json:"schema,omitempty"`
+	Extends  ConfigNames `db:"extends" json:"extends,omitempty"`
+	Created  time.Time   `db:"created_at" json:"created_at"`
+}
+
+type ConfigNames []string
+
 type Monitoring struct {
 	Enabled bool `db:"enabled" json:"enabled"`
 }
@@ -49,6 +145,15 @@ type Limits struct {
 
 const databaseFile = "state.db"
 
+// configColumns lists configs table columns in order matching Config struct
+const configColumns = `id, name, metadata, schema, revision, created_at, cloned_from, cloned_revision, extends, labels`
+
+var (
+	ErrConfigExists     = errors.New("configuration item already exists")
+	ErrRevisionMismatch = errors.New("configuration item has been modified concurrently")
+	ErrConfigInUse      = errors.New("configuration item is referenced by other configuration items")
+)
+
 // Scan performs custom-type conversion, deserialize stream of bytes into struct
 func (m *Metadata) Scan(src interface{}) error {
 
@@ -71,53 +176,124 @@ func (m *Metadata) Scan(src interface{}) error {
 		return fmt.Errorf("unexpected data type %t", t)
 	}
 
-	return nil
+	// secret values are kept decrypted in memory
+	return openMetadata(m)
 }
 
-// Value performs custom-type conversion, serialize struct into stream of bytes
+// Value performs custom-type conversion, serialize struct into stream of bytes, secret values are encrypted
 func (m *Metadata) Value() (driver.Value, error) {
-	return json.Marshal(&m)
+	buf, err := json.Marshal(&m)
+	if err != nil || !bytes.Contains(buf, []byte(`"`+secretKey+`"`)) {
+		return buf, err
+	}
+
+	sealed, err := sealMetadata(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// Scan performs custom-type conversion, deserialize JSON array of names
+func (n *ConfigNames) Scan(src interface{}) error {
+	switch t := src.(type) {
+	case nil:
+		*n = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(t, n)
+	case string:
+		return json.Unmarshal([]byte(t), n)
+	default:
+		return fmt.Errorf("unexpected data type %t", t)
+	}
+}
+
+// Value performs custom-type conversion, serialize names as JSON array
+func (n ConfigNames) Value() (driver.Value, error) {
+	if n == nil {
+		return "[]", nil
+	}
+	buf, err := json.Marshal([]string(n))
+	return string(buf), err
+}
+
+// databasePath returns database file location, stored somewhere else if defined
+func databasePath() string {
+	return getStringOrDefault("SERVE_DATA", databaseFile)
 }
 
 // NewDatabaseStore prepares connection to database
 func NewDatabaseStore() (*Database, func(), error) {
 
-	// store database somewhere else if defined
-	path := getStringOrDefault("SERVE_DATA", databaseFile)
+	path := databasePath()
+
+	// secret metadata values are sealed with keys from local key file
+	if err := loadSecretKeyring(); err != nil {
+		return nil, nil, fmt.Errorf("unable to load secrets key file: %w", err)
+	}
+
+	opts, err := newDatabaseOptions()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// check if database file exists
 	initDB := !existDir(path)
 
-	// open or create database file
-	openFileDB, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?cache=shared&_loc=auto", path))
+	// open or create database file, sqlite allows single writer at a time so writes queue up on one connection
+	writer, err := sqlx.Open("sqlite3", opts.dsn(path, true))
 	if err != nil {
 		return nil, nil, err
 	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(opts.ConnMaxLifetime)
 
 	// close database on initialization error
 	defer func() {
 		if err != nil {
-			openFileDB.Close()
+			writer.Close()
 		}
 	}()
 
 	// check connection
-	if err = openFileDB.Ping(); err != nil {
+	if err = writer.Ping(); err != nil {
 		return nil, nil, err
 	}
 
 	// preapre db
 	if initDB {
-		if err := initializeDb(openFileDB); err != nil {
+		if err = initializeDb(writer); err != nil {
 			return nil, nil, err
 		}
 	}
 
-	db := &Database{DB: openFileDB}
+	// bring structure up to date
+	if err = migrateDb(writer); err != nil {
+		return nil, nil, err
+	}
+
+	// readers run in parallel, in wal mode they are not blocked by the writer
+	reader, err := sqlx.Open("sqlite3", opts.dsn(path, false))
+	if err != nil {
+		return nil, nil, err
+	}
+	reader.SetMaxOpenConns(opts.MaxOpenConns)
+	reader.SetMaxIdleConns(opts.MaxIdleConns)
+	reader.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	if err = reader.Ping(); err != nil {
+		reader.Close()
+		return nil, nil, err
+	}
+
+	db := &Database{DB: reader, writer: writer}
 
 	// cleaner
 	closeFunc := func() {
-		openFileDB.Close()
+		reader.Close()
+		writer.Close()
 	}
 
 	return db, closeFunc, nil
@@ -182,35 +358,25 @@ func initializeDb(db *sqlx.DB) error {
 
 // InsertConfig inserts Config struct into database file
 func (db *Database) InsertConfig(cfg *Config) (int, error) {
+	var id int
 
-	// insert statement
-	stmt := `INSERT INTO configs (name, metadata, created_at) VALUES (?, ?, datetime('now'))`
-
-	// execute DML statement
-	result, err := db.Exec(stmt, cfg.Name, cfg.Metadata)
-	if err != nil {
-		return 0, err
-	}
-
-	// get newly created record id
-	id, err := result.LastInsertId()
+	err := db.inTx(func(tx *sqlx.Tx) (err error) {
+		id, err = insertConfigTx(tx, cfg)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	return int(id), nil
+	return id, nil
 }
 
 // GetConfigById retrieves Config by its id
 func (db *Database) GetConfigById(id int) (*Config, error) {
-	stmt := `SELECT id, name, metadata, created_at FROM configs	WHERE id = ?`
-
-	row := db.QueryRow(stmt, id)
+	stmt := `SELECT ` + configColumns + ` FROM configs WHERE id = ?`
 
 	cfg := &Config{}
-
-	err := row.Scan(&cfg.ID, &cfg.Name, &cfg.Metadata, &cfg.Created)
-	if err != nil {
+	if err := db.Get(cfg, stmt, id); err != nil {
 		return nil, err
 	}
 
@@ -219,14 +385,10 @@ func (db *Database) GetConfigById(id int) (*Config, error) {
 
 // GetConfigByName retrieves Config by its name
 func (db *Database) GetConfigByName(name string) (*Config, error) {
-	stmt := `SELECT id, name, metadata, created_at FROM configs	WHERE name = ?`
-
-	row := db.QueryRow(stmt, name)
+	stmt := `SELECT ` + configColumns + ` FROM configs WHERE name = ?`
 
 	cfg := &Config{}
-
-	err := row.Scan(&cfg.ID, &cfg.Name, &cfg.Metadata, &cfg.Created)
-	if err != nil {
+	if err := db.Get(cfg, stmt, name); err != nil {
 		return nil, err
 	}
 
@@ -235,7 +397,7 @@ func (db *Database) GetConfigByName(name string) (*Config, error) {
 
 // GetConfigs retrieves all Configs
 func (db *Database) GetConfigs() (*[]Config, error) {
-	stmt := `SELECT id, name, metadata, created_at FROM configs ORDER BY created_at ASC`
+	stmt := `SELECT ` + configColumns + ` FROM configs ORDER BY created_at ASC`
 
 	cfgs := []Config{}
 	if err := db.Select(&cfgs, stmt); err != nil {
@@ -245,35 +407,421 @@ func (db *Database) GetConfigs() (*[]Config, error) {
 	return &cfgs, nil
 }
 
-// DeleteConfigByName removes Config by its name
+// DeleteConfigByName removes Config by its name along with its history and overlays
 func (db *Database) DeleteConfigByName(name string) error {
-	stmt := `DELETE FROM configs WHERE name = ?`
+	return db.inTx(func(tx *sqlx.Tx) error {
+		return deleteConfigTx(tx, name)
+	})
+}
+
+// UpdateConfigByName replaces Config metadata, schema and parents by its name, previous revision goes to history,
+// sql.ErrNoRows is returned if there is nothing to update
+func (db *Database) UpdateConfigByName(name string, cfg *Config) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		current, err := getConfigTx(tx, name)
+		if err != nil {
+			return err
+		}
+
+		return updateConfigTx(tx, current, cfg)
+	})
+}
+
+// UpsertConfig inserts Config or replaces existing one with the same name, reports whether it was created
+func (db *Database) UpsertConfig(cfg *Config) (bool, error) {
+	created := false
+
+	err := db.inTx(func(tx *sqlx.Tx) error {
+		current, err := getConfigTx(tx, cfg.Name)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			created = true
+			cfg.ID, err = insertConfigTx(tx, cfg)
+			return err
+		case err != nil:
+			return err
+		}
+
+		cfg.ID = current.ID
+		return updateConfigTx(tx, current, cfg)
+	})
+
+	return created, err
+}
+
+// RenameConfig changes Config name, id, revision and history are kept
+func (db *Database) RenameConfig(name, newName string) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		before, err := getConfigTx(tx, name)
+		if err != nil {
+			return err
+		}
+
+		// dependents refer to config by name, renaming would break them
+		if err := checkDependentsTx(tx, name); err != nil {
+			return err
+		}
+
+		if _, err := getConfigTx(tx, newName); err == nil {
+			return ErrConfigExists
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE configs SET name = ? WHERE name = ?`, newName, name); err != nil {
+			return nameTaken(err)
+		}
 
-	query, err := db.Prepare(stmt)
+		// pending scheduled changes follow the config
+		stmt := `UPDATE pending_changes SET name = ? WHERE name = ? AND status = ?`
+		if _, err := tx.Exec(stmt, newName, name, scheduleStatusPending); err != nil {
+			return err
+		}
+
+		// consumers keyed by name see the old one go and the new one appear
+		if err := recordChangeTx(tx, eventDeleted, before); err != nil {
+			return err
+		}
+		return recordStateTx(tx, eventCreated, newName)
+	})
+}
+
+// CloneConfig creates cfg as a copy of source, fails if source has changed since it was read
+func (db *Database) CloneConfig(source *Config, cfg *Config) (int, error) {
+	var id int64
+
+	err := db.inTx(func(tx *sqlx.Tx) error {
+		current, err := getConfigTx(tx, source.Name)
+		if err != nil {
+			return err
+		}
+		if current.Revision != source.Revision {
+			return ErrRevisionMismatch
+		}
+
+		if _, err := getConfigTx(tx, cfg.Name); err == nil {
+			return ErrConfigExists
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		stmt := `INSERT INTO configs (name, metadata, schema, extends, labels, revision, cloned_from, cloned_revision, created_at) VALUES (?, ?, ?, ?, ?, 1, ?, ?, datetime('now'))`
+
+		result, err := tx.Exec(stmt, cfg.Name, cfg.Metadata, cfg.Schema, cfg.Extends, cfg.Labels, current.Name, current.Revision)
+		if err != nil {
+			return nameTaken(err)
+		}
+
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		return recordStateTx(tx, eventCreated, cfg.Name)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetConfigHistory retrieves every revision of Config, current one is the last
+func (db *Database) GetConfigHistory(name string) (*[]ConfigRevision, error) {
+	stmt := `
+	SELECT h.revision, h.metadata, h.schema, h.extends, h.created_at FROM config_history h
+		JOIN configs c ON c.id = h.config_id WHERE c.name = ?
+	UNION ALL
+	SELECT revision, metadata, schema, extends, created_at FROM configs WHERE name = ?
+	ORDER BY 1 ASC`
+
+	revs := []ConfigRevision{}
+	if err := db.Select(&revs, stmt, name, name); err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &revs, nil
+}
+
+// GetConfigDependents retrieves names of configs extending or referencing Config, overlays included
+func (db *Database) GetConfigDependents(name string) (*[]string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	names, err := dependentsTx(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &names, nil
+}
+
+// dependentsTx finds configs extending name or referencing it with ${ref:name...} placeholders, directly
+// or from one of their environment overlays
+func dependentsTx(tx *sqlx.Tx, name string) ([]string, error) {
+	stmt := `
+	SELECT name FROM configs WHERE name != ? AND (
+		instr(metadata, '${ref:' || ? || '#') > 0 OR
+		instr(metadata, '${ref:' || ? || '}') > 0 OR
+		EXISTS (SELECT 1 FROM json_each(configs.extends) WHERE json_each.value = ?)
+	)
+	UNION
+	SELECT c.name FROM config_overlays o JOIN configs c ON c.id = o.config_id WHERE c.name != ? AND (
+		instr(o.metadata, '${ref:' || ? || '#') > 0 OR
+		instr(o.metadata, '${ref:' || ? || '}') > 0
+	)
+	ORDER BY 1 ASC`
+
+	names := []string{}
+	if err := tx.Select(&names, stmt, name, name, name, name, name, name, name); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// checkDependentsTx fails with ErrConfigInUse if anything depends on name
+func checkDependentsTx(tx *sqlx.Tx, name string) error {
+	names, err := dependentsTx(tx, name)
 	if err != nil {
 		return err
 	}
+	if len(names) > 0 {
+		return fmt.Errorf("%w: %s", ErrConfigInUse, strings.Join(names, ", "))
+	}
+	return nil
+}
 
-	_, err = query.Exec(name)
+// nameTaken maps violation of unique config name index to ErrConfigExists, it guards names written by
+// concurrent transactions the existence checks above could not see
+func nameTaken(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrConfigExists
+	}
 	return err
 }
 
-func (db *Database) UpdateConfigByName(name string, cfg *Config) error {
-	stmt := `UPDATE configs SET metadata = ? WHERE name = ?`
+// insertConfigTx inserts Config within transaction, names are kept unique
+func insertConfigTx(tx *sqlx.Tx, cfg *Config) (int, error) {
+
+	// names are used as identifiers, keep them unique
+	if _, err := getConfigTx(tx, cfg.Name); err == nil {
+		return 0, ErrConfigExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	// insert statement
+	stmt := `INSERT INTO configs (name, metadata, schema, extends, labels, revision, created_at) VALUES (?, ?, ?, ?, ?, 1, datetime('now'))`
 
 	// execute DML statement
-	_, err := db.Exec(stmt, cfg.Metadata, name)
+	result, err := tx.Exec(stmt, cfg.Name, cfg.Metadata, cfg.Schema, cfg.Extends, cfg.Labels)
+	if err != nil {
+		return 0, nameTaken(err)
+	}
+
+	// get newly created record id
+	id, err := result.LastInsertId()
 	if err != nil {
+		return 0, err
+	}
+
+	if err := recordStateTx(tx, eventCreated, cfg.Name); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// deleteConfigTx removes Config within transaction unless other configs depend on it
+func deleteConfigTx(tx *sqlx.Tx, name string) error {
+	if err := checkDependentsTx(tx, name); err != nil {
+		return err
+	}
+
+	// last state goes to the change log, there is nothing to record if config is missing already
+	before, err := getConfigTx(tx, name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	if err := recordChangeTx(tx, eventDeleted, before); err != nil {
 		return err
 	}
 
+	// history and overlays go along with config
+	stmts := []string{
+		`DELETE FROM config_history WHERE config_id IN (SELECT id FROM configs WHERE name = ?)`,
+		`DELETE FROM overlay_history WHERE config_id IN (SELECT id FROM configs WHERE name = ?)`,
+		`DELETE FROM config_overlays WHERE config_id IN (SELECT id FROM configs WHERE name = ?)`,
+		`DELETE FROM configs WHERE name = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, name); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// getConfigTx retrieves Config by its name within transaction
+func getConfigTx(tx *sqlx.Tx, name string) (*Config, error) {
+	stmt := `SELECT ` + configColumns + ` FROM configs WHERE name = ?`
+
+	cfg := &Config{}
+	if err := tx.Get(cfg, stmt, name); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// updateConfigTx archives current revision of Config and stores the new one
+func updateConfigTx(tx *sqlx.Tx, current *Config, cfg *Config) error {
+	stmt := `INSERT INTO config_history (config_id, revision, metadata, schema, extends, created_at) VALUES (?, ?, ?, ?, ?, datetime('now'))`
+	if _, err := tx.Exec(stmt, current.ID, current.Revision, current.Metadata, current.Schema, current.Extends); err != nil {
+		return err
+	}
+
+	stmt = `UPDATE configs SET metadata = ?, schema = ?, extends = ?, labels = ?, revision = revision + 1 WHERE id = ?`
+	if _, err := tx.Exec(stmt, cfg.Metadata, cfg.Schema, cfg.Extends, cfg.Labels, current.ID); err != nil {
+		return err
+	}
+
+	return recordStateTx(tx, eventUpdated, current.Name)
+}
+
+// Batch executes fn within single transaction, commits when fn succeeds
+func (db *Database) Batch(fn func(tx StoreTx) error) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		return fn(&dbTx{tx: tx})
+	})
+}
+
+// dbTx exposes store operations bound to a transaction
+type dbTx struct {
+	tx *sqlx.Tx
+}
+
+// GetConfigByName retrieves Config by its name
+func (t *dbTx) GetConfigByName(name string) (*Config, error) {
+	return getConfigTx(t.tx, name)
+}
+
+// GetSchema retrieves schema by its name
+func (t *dbTx) GetSchema(name string) (*Schema, error) {
+	return getSchema(t.tx, name)
+}
+
+// InsertConfig inserts Config
+func (t *dbTx) InsertConfig(cfg *Config) (int, error) {
+	return insertConfigTx(t.tx, cfg)
+}
+
+// UpdateConfigByName replaces Config, sql.ErrNoRows is returned if there is nothing to update
+func (t *dbTx) UpdateConfigByName(name string, cfg *Config) error {
+	current, err := getConfigTx(t.tx, name)
+	if err != nil {
+		return err
+	}
+	return updateConfigTx(t.tx, current, cfg)
+}
+
+// DeleteConfigByName removes Config, sql.ErrNoRows is returned if there is nothing to delete
+func (t *dbTx) DeleteConfigByName(name string) error {
+	if _, err := getConfigTx(t.tx, name); err != nil {
+		return err
+	}
+	return deleteConfigTx(t.tx, name)
+}
+
+// Savepoint marks a point the transaction can be partially rolled back to
+func (t *dbTx) Savepoint(name string) error {
+	_, err := t.tx.Exec(`SAVEPOINT ` + name)
+	return err
+}
+
+// RollbackTo discards changes made since savepoint
+func (t *dbTx) RollbackTo(name string) error {
+	_, err := t.tx.Exec(`ROLLBACK TO ` + name)
+	return err
+}
+
+// Release forgets savepoint keeping changes made since
+func (t *dbTx) Release(name string) error {
+	_, err := t.tx.Exec(`RELEASE ` + name)
+	return err
+}
+
+// inTx executes fn within transaction, commits on success and rolls back on error,
+// audited views record audit entries of the transaction before it is committed
+func (db *Database) inTx(fn func(tx *sqlx.Tx) error) (err error) {
+
+	// use transaction
+	tx, err := db.writer.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// rollback or commit
+	var entries []AuditEntry
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else if err = tx.Commit(); err == nil {
+			db.audit.committed(entries)
+		}
+	}()
+
+	if db.audit == nil {
+		return fn(tx)
+	}
+
+	before, err := db.audit.snapshotTx(tx)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	entries, err = db.audit.recordTx(tx, before)
+	return err
+}
+
+// execTx executes single statement within transaction so that audited views record it,
+// sql.ErrNoRows is returned and nothing is recorded when no row was affected
+func (db *Database) execTx(stmt string, args ...interface{}) (result sql.Result, err error) {
+	err = db.inTx(func(tx *sqlx.Tx) error {
+		if result, err = tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	return result, err
+}
+
 // IsConnected verifies connection to database
 func (db *Database) IsConnected() bool {
 	if err := db.DB.Ping(); err != nil {
 		return false
 	}
+	if err := db.writer.Ping(); err != nil {
+		return false
+	}
 	return true
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestFileDatabaseStore(t *testing.T) {
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		dir := t.TempDir()
+		os.Setenv("SERVE_DATA", filepath.Join(dir, "state.db"))
+		defer os.Unsetenv("SERVE_DATA")
+
+		db, closeDB, err := NewDatabaseStore()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer closeDB()
+
+		var mode string
+		if err := db.Get(&mode, `PRAGMA journal_mode`); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if mode != "wal" {
+			t.Errorf("expected journal mode %q but got %q", "wal", mode)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 32)
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("cfg-%d", i)
+				if _, err := db.InsertConfig(&Config{Name: name, Metadata: &Metadata{"i": "0"}}); err != nil {
+					errs <- err
+					return
+				}
+				if err := db.UpdateConfigByName(name, &Config{Name: name, Metadata: &Metadata{"i": "1"}}); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := db.GetConfigs(); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Error("Unexpected error:", err)
+		}
+
+		if err := db.Backup(filepath.Join(dir, "snapshot.db")); err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		os.Setenv("SERVE_DB_SYNCHRONOUS", "sometimes")
+		defer os.Unsetenv("SERVE_DB_SYNCHRONOUS")
+
+		if _, err := newDatabaseOptions(); err == nil {
+			t.Error("expected unsupported synchronous mode to be rejected")
+		}
+	})
+
+}
+
+func TestUniqueConfigNames(t *testing.T) {
+
+	t.Run("duplicates are renamed", func(t *testing.T) {
+		db, err := sqlx.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		var unique int
+		for idx, stmt := range schemaMigrations {
+			if strings.Contains(stmt, "CREATE UNIQUE INDEX idx_configs_name") {
+				unique = idx + 1
+			}
+		}
+		for idx := 0; idx < unique-1; idx++ {
+			if err := applyMigration(db, idx+1, schemaMigrations[idx]); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := db.Exec(`INSERT INTO configs (name, metadata, created_at) VALUES ('abc', '{}', datetime('now'))`); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+
+		if err := migrateDb(db); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		names := []string{}
+		if err := db.Select(&names, `SELECT name FROM configs ORDER BY id`); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if got := strings.Join(names, ","); got != "abc,abc-2" {
+			t.Errorf("expected duplicate to be renamed but got %q", got)
+		}
+	})
+
+	t.Run("constraint maps to conflict", func(t *testing.T) {
+		_, db := newTestServer(t, nil, func(db *Database) error {
+			_, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{}})
+			return err
+		})
+
+		_, err := db.writer.Exec(`INSERT INTO configs (name, metadata, created_at) VALUES ('abc', '{}', datetime('now'))`)
+		if !errors.Is(nameTaken(err), ErrConfigExists) {
+			t.Errorf("expected %v but got %v", ErrConfigExists, err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type DatabaseOptions struct {
+	JournalMode     string
+	Synchronous     string
+	BusyTimeout     int
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+const (
+	defaultJournalMode  = "WAL"
+	defaultSynchronous  = "NORMAL"
+	defaultBusyTimeout  = 5000
+	defaultMaxOpenConns = 4
+	defaultMaxIdleConns = 4
+)
+
+var (
+	journalModes = map[string]bool{"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true}
+	syncModes    = map[string]bool{"OFF": true, "NORMAL": true, "FULL": true, "EXTRA": true}
+)
+
+// newDatabaseOptions reads sqlite tuning from environment variables
+func newDatabaseOptions() (*DatabaseOptions, error) {
+	opts := &DatabaseOptions{
+		JournalMode:     strings.ToUpper(getStringOrDefault("SERVE_DB_JOURNAL_MODE", defaultJournalMode)),
+		Synchronous:     strings.ToUpper(getStringOrDefault("SERVE_DB_SYNCHRONOUS", defaultSynchronous)),
+		BusyTimeout:     getIntOrDefault("SERVE_DB_BUSY_TIMEOUT", defaultBusyTimeout),
+		MaxOpenConns:    getIntOrDefault("SERVE_DB_MAX_OPEN_CONNS", defaultMaxOpenConns),
+		MaxIdleConns:    getIntOrDefault("SERVE_DB_MAX_IDLE_CONNS", defaultMaxIdleConns),
+		ConnMaxLifetime: time.Duration(getIntOrDefault("SERVE_DB_CONN_MAX_LIFETIME", 0)) * time.Second,
+	}
+
+	if !journalModes[opts.JournalMode] {
+		return nil, fmt.Errorf("unsupported journal mode %q", opts.JournalMode)
+	}
+	if !syncModes[opts.Synchronous] {
+		return nil, fmt.Errorf("unsupported synchronous mode %q", opts.Synchronous)
+	}
+	if opts.BusyTimeout < 0 {
+		return nil, fmt.Errorf("busy timeout must not be negative")
+	}
+
+	return opts, nil
+}
+
+// dsn builds connection string for database file, writer begins transactions with write lock taken upfront
+// while readers are not allowed to change anything
+func (opts *DatabaseOptions) dsn(path string, writer bool) string {
+	params := url.Values{}
+	params.Set("_loc", "auto")
+	params.Set("_journal_mode", opts.JournalMode)
+	params.Set("_synchronous", opts.Synchronous)
+	params.Set("_busy_timeout", strconv.Itoa(opts.BusyTimeout))
+	if writer {
+		params.Set("_txlock", "immediate")
+	} else {
+		params.Set("_query_only", "true")
+	}
+
+	return fmt.Sprintf("file:%s?%s", path, params.Encode())
+}
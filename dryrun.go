@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type MetadataChange struct {
+	Op   string      `json:"op"`
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+type DryRunResult struct {
+	DryRun bool             `json:"dry_run"`
+	Status int              `json:"status"`
+	Config *Config          `json:"config,omitempty"`
+	Diff   []MetadataChange `json:"diff"`
+}
+
+const (
+	changeAdd     = "add"
+	changeRemove  = "remove"
+	changeReplace = "replace"
+)
+
+// dryRunRoutes lists route templates whose mutations are able to only preview their effect
+var dryRunRoutes = map[string]bool{
+	"/configs":        true,
+	"/configs:batch":  true,
+	"/configs/{name}": true,
+	"/import":         true,
+}
+
+// isDryRun reports whether request asks to only preview its effect
+func isDryRun(r *http.Request) bool {
+	return isTrue(r.URL.Query().Get("dryRun"))
+}
+
+// dryRunMiddleware refuses dryRun on mutations which cannot preview their effect instead of performing them
+func (srv *WebServer) dryRunMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isDryRun(r) && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+			if tpl, err := mux.CurrentRoute(r).GetPathTemplate(); err != nil || !dryRunRoutes[tpl] {
+				http.Error(w, "dryRun is not supported by this endpoint", http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// diffMetadata lists changes turning before into after, nested objects are compared key by key
+func diffMetadata(before, after *Metadata) ([]MetadataChange, error) {
+	b, err := genericMetadata(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := genericMetadata(after)
+	if err != nil {
+		return nil, err
+	}
+
+	// missing metadata compares as empty object
+	if b == nil {
+		b = map[string]interface{}{}
+	}
+	if a == nil {
+		a = map[string]interface{}{}
+	}
+
+	changes := []MetadataChange{}
+	diffValues("", b, a, &changes)
+	return changes, nil
+}
+
+// diffValues appends changes between two decoded json values at path
+func diffValues(path string, before, after interface{}, changes *[]MetadataChange) {
+	bm, bok := before.(map[string]interface{})
+	am, aok := after.(map[string]interface{})
+	if !bok || !aok {
+		switch {
+		case reflect.DeepEqual(before, after):
+		case before == nil:
+			*changes = append(*changes, MetadataChange{Op: changeAdd, Path: path, New: after})
+		case after == nil:
+			*changes = append(*changes, MetadataChange{Op: changeRemove, Path: path, Old: before})
+		default:
+			*changes = append(*changes, MetadataChange{Op: changeReplace, Path: path, Old: before, New: after})
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(bm)+len(am))
+	for key := range bm {
+		keys = append(keys, key)
+	}
+	for key := range am {
+		if _, ok := bm[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		diffValues(joinPath(path, key), bm[key], am[key], changes)
+	}
+}
+
+// writeDryRun responds with would-be config and its diff against current state
+func writeDryRun(w http.ResponseWriter, status int, current, next *Config) {
+	var before, after *Metadata
+	if current != nil {
+		before = current.Metadata
+	}
+	if next != nil {
+		after = next.Metadata
+	}
+
+	diff, err := diffMetadata(before, after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// previews show which secrets change but never their values
+	result := DryRunResult{DryRun: true, Status: status, Config: redactedConfig(next), Diff: redactDiff(diff)}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// dryRunDelete previews DELETE /configs/abc, configs still referenced by others are reported as conflict
+func (srv *WebServer) dryRunDelete(w http.ResponseWriter, name string) {
+	current, err := srv.store.GetConfigByName(name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if current != nil {
+		names, err := srv.store.GetConfigDependents(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if names != nil && len(*names) > 0 {
+			http.Error(w, fmt.Sprintf("%s: %s", ErrConfigInUse, strings.Join(*names, ", ")), http.StatusConflict)
+			return
+		}
+	}
+
+	writeDryRun(w, http.StatusOK, current, nil)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			if _, err := db.InsertConfig(&Config{Name: "base", Metadata: &Metadata{"a": "1", "b": "2"}}); err != nil {
+				return err
+			}
+			_, err := db.InsertConfig(&Config{Name: "child", Metadata: &Metadata{}, Extends: ConfigNames{"base"}})
+			return err
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPatch,
+				path:   "/configs/base?dryRun=true",
+				body:   strings.NewReader(`{"metadata":{"a":"1","c":"3"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `{"dry_run":true,"status":200,"config":{"id":1,"name":"base","metadata":{"a":"1","c":"3"}},"diff":[{"op":"remove","path":"b","old":"2"},{"op":"add","path":"c","new":"3"}]}`)
+				},
+			},
+			{
+				method: http.MethodPut,
+				path:   "/configs/fresh?dryRun=true",
+				body:   strings.NewReader(`{"metadata":{"x":"1"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusCreated)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs?dryRun=true",
+				body:   strings.NewReader(`{"name":"base","metadata":{}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/base?dryRun=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs:batch?dryRun=true",
+				body:   strings.NewReader(`{"operations":[{"op":"create","name":"other","metadata":{"y":"1"}},{"op":"delete","name":"child"}]}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+
+					var resp BatchResponse
+					if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if resp.Committed || !resp.DryRun || len(resp.Results) != 2 || len(resp.Results[0].Diff) != 1 {
+						t.Errorf("unexpected dry run batch %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":1,"name":"base","metadata":{"a":"1","b":"2"}},{"id":2,"name":"child","metadata":{},"extends":["base"]}]`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			_, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"a": "1"}})
+			return err
+		})
+
+		for _, req := range []struct{ method, path, body string }{
+			{"POST", "/configs/abc/rename?dryRun=true", `{"name":"xyz"}`},
+			{"POST", "/configs/abc/clone?dryRun=true", `{"name":"xyz"}`},
+			{"PUT", "/configs/abc/overlays/prod?dryRun=true", `{"metadata":{"a":"2"}}`},
+			{"DELETE", "/configs/abc/overlays/prod?dryRun=true", ``},
+			{"POST", "/configs/abc/schedules?dryRun=true", `{"metadata":{"a":"3"},"effective_at":"2030-01-01T00:00:00Z"}`},
+			{"POST", "/schemas/abc?dryRun=true", `{"type":"object"}`},
+		} {
+			res := getResponse(t, srv, req.method, req.path, strings.NewReader(req.body))
+			assertResponseCode(t, res.StatusCode, http.StatusBadRequest)
+		}
+
+		// nothing has been changed
+		res := getResponse(t, srv, "GET", "/configs", nil)
+		assertResponseBody(t, readBody(t, res), `[{"id":1,"name":"abc","metadata":{"a":"1"}}]`)
+		res = getResponse(t, srv, "GET", "/configs/abc/overlays", nil)
+		assertResponseBody(t, readBody(t, res), `[]`)
+		res = getResponse(t, srv, "GET", "/schedules", nil)
+		assertResponseBody(t, readBody(t, res), `[]`)
+		res = getResponse(t, srv, "GET", "/schemas", nil)
+		assertResponseBody(t, readBody(t, res), `[]`)
+
+		// reads ignore it
+		res = getResponse(t, srv, "GET", "/configs/abc?dryRun=true", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	})
+
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type ConfigEvent struct {
+	Seq      uint64    `json:"seq"`
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	Env      string    `json:"env,omitempty"`
+	Revision int       `json:"revision,omitempty"`
+	Config   *Config   `json:"config,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+
+	eventOverlayCreated = "overlay_created"
+	eventOverlayUpdated = "overlay_updated"
+	eventOverlayDeleted = "overlay_deleted"
+)
+
+// eventTypes lists every type of recorded change
+var eventTypes = []string{eventCreated, eventUpdated, eventDeleted, eventOverlayCreated, eventOverlayUpdated, eventOverlayDeleted}
+
+const (
+	defaultEventHistory = 1024
+	subscriptionBacklog = 64
+)
+
+// EventBus numbers config events with global sequence and fans them out to subscribers,
+// recent events are kept so subscribers are able to resume
+type EventBus struct {
+	mu       sync.Mutex
+	seq      uint64
+	capacity int
+	history  []ConfigEvent
+	subs     map[*Subscription]struct{}
+}
+
+// Subscription receives matching events on C, C is closed when subscriber falls behind or is closed
+type Subscription struct {
+	C     chan ConfigEvent
+	Lost  bool
+	bus   *EventBus
+	match func(*ConfigEvent) bool
+}
+
+// NewEventBus creates bus keeping up to capacity recent events
+func NewEventBus(capacity int) *EventBus {
+	return &EventBus{capacity: capacity, subs: map[*Subscription]struct{}{}}
+}
+
+// Seq returns sequence number of the latest event
+func (b *EventBus) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Advance moves sequence forward to seq without publishing anything
+func (b *EventBus) Advance(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if seq > b.seq {
+		b.seq = seq
+	}
+}
+
+// Publish assigns sequence numbers to events lacking one and delivers them, subscribers which can not keep up are dropped
+func (b *EventBus) Publish(events ...ConfigEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		// events coming from the change log carry their sequence number already
+		if event.Seq == 0 {
+			event.Seq = b.seq + 1
+		}
+		b.seq = event.Seq
+		if event.Time.IsZero() {
+			event.Time = time.Now().UTC()
+		}
+
+		b.history = append(b.history, event)
+		if len(b.history) > b.capacity {
+			b.history = b.history[len(b.history)-b.capacity:]
+		}
+
+		for sub := range b.subs {
+			if sub.match != nil && !sub.match(&event) {
+				continue
+			}
+			select {
+			case sub.C <- event:
+			default:
+				sub.Lost = true
+				b.drop(sub)
+			}
+		}
+	}
+}
+
+// Subscribe registers subscriber for events matching match, events after since which are still kept
+// are returned for replay, ok is false when some of them are gone already
+func (b *EventBus) Subscribe(since uint64, match func(*ConfigEvent) bool) (sub *Subscription, replay []ConfigEvent, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{C: make(chan ConfigEvent, subscriptionBacklog), bus: b, match: match}
+	b.subs[sub] = struct{}{}
+
+	ok = true
+	if since < b.seq {
+		if len(b.history) == 0 || b.history[0].Seq > since+1 {
+			ok = false
+		}
+		for _, event := range b.history {
+			if event.Seq > since && (match == nil || match(&event)) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	return sub, replay, ok
+}
+
+// Close unregisters subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+// drop unregisters subscription and closes its channel, bus lock must be held
+func (b *EventBus) drop(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.C)
+}
+
+// EventStore is DatabaseStore relaying changes it records to the bus, so sequence numbers of events
+// and of the change log are the same
+type EventStore struct {
+	DatabaseStore
+	bus *EventBus
+
+	// shared by the store and its audited views
+	mu *sync.Mutex
+}
+
+// NewEventStore wraps store so its mutations are published to bus, bus continues from the latest recorded change
+func NewEventStore(store DatabaseStore, bus *EventBus) (*EventStore, error) {
+	seq, err := store.LastChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+	bus.Advance(seq)
+
+	return &EventStore{DatabaseStore: store, bus: bus, mu: &sync.Mutex{}}, nil
+}
+
+// Audited returns audited view of the store publishing to the same bus
+func (s *EventStore) Audited(scope *auditScope) DatabaseStore {
+	return &EventStore{DatabaseStore: s.DatabaseStore.Audited(scope), bus: s.bus, mu: s.mu}
+}
+
+// publish relays changes recorded since the last published one
+func (s *EventStore) publish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		changes, err := s.DatabaseStore.GetChanges(s.bus.Seq(), maxChangesLimit)
+		if err != nil || len(*changes) == 0 {
+			return
+		}
+		s.bus.Publish(*changes...)
+		if len(*changes) < maxChangesLimit {
+			return
+		}
+	}
+}
+
+// InsertConfig inserts Config
+func (s *EventStore) InsertConfig(cfg *Config) (int, error) {
+	id, err := s.DatabaseStore.InsertConfig(cfg)
+	if err == nil {
+		s.publish()
+	}
+	return id, err
+}
+
+// UpdateConfigByName replaces Config by its name
+func (s *EventStore) UpdateConfigByName(name string, cfg *Config) error {
+	err := s.DatabaseStore.UpdateConfigByName(name, cfg)
+	if err == nil {
+		s.publish()
+	}
+	return err
+}
+
+// UpsertConfig inserts or replaces Config
+func (s *EventStore) UpsertConfig(cfg *Config) (bool, error) {
+	created, err := s.DatabaseStore.UpsertConfig(cfg)
+	if err == nil {
+		s.publish()
+	}
+	return created, err
+}
+
+// DeleteConfigByName removes Config by its name
+func (s *EventStore) DeleteConfigByName(name string) error {
+	err := s.DatabaseStore.DeleteConfigByName(name)
+	if err == nil {
+		s.publish()
+	}
+	return err
+}
+
+// RenameConfig changes Config name
+func (s *EventStore) RenameConfig(name, newName string) error {
+	err := s.DatabaseStore.RenameConfig(name, newName)
+	if err == nil {
+		s.publish()
+	}
+	return err
+}
+
+// CloneConfig inserts copy of source Config
+func (s *EventStore) CloneConfig(source, cfg *Config) (int, error) {
+	id, err := s.DatabaseStore.CloneConfig(source, cfg)
+	if err == nil {
+		s.publish()
+	}
+	return id, err
+}
+
+// ApplyScheduledChanges applies due changes
+func (s *EventStore) ApplyScheduledChanges(now time.Time, validate configValidator) (*[]ScheduledChange, error) {
+	chgs, err := s.DatabaseStore.ApplyScheduledChanges(now, validate)
+
+	// changes applied before an error are committed already
+	s.publish()
+	return chgs, err
+}
+
+// PutOverlay creates or replaces environment overlay of Config
+func (s *EventStore) PutOverlay(ovr *Overlay) (bool, error) {
+	created, err := s.DatabaseStore.PutOverlay(ovr)
+	if err == nil {
+		s.publish()
+	}
+	return created, err
+}
+
+// DeleteOverlay removes environment overlay of Config
+func (s *EventStore) DeleteOverlay(name, env string) error {
+	err := s.DatabaseStore.DeleteOverlay(name, env)
+	if err == nil {
+		s.publish()
+	}
+	return err
+}
+
+// Batch executes fn within single transaction, changes are published only once it is committed
+func (s *EventStore) Batch(fn func(tx StoreTx) error) error {
+	err := s.DatabaseStore.Batch(fn)
+	if err == nil {
+		s.publish()
+	}
+	return err
+}
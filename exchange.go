@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigRecord is the interchange format of single config, one per line in jsonl
+type ConfigRecord struct {
+	Config
+	History []ConfigRevision `json:"history,omitempty"`
+}
+
+type ImportResult struct {
+	Line   int          `json:"line"`
+	Name   string       `json:"name,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type ImportResponse struct {
+	DryRun  bool           `json:"dry_run,omitempty"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+const (
+	formatJSONL = "jsonl"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+const (
+	importModeMerge   = "merge"
+	importModeReplace = "replace"
+	importModeSkip    = "skip"
+)
+
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importSkipped = "skipped"
+	importFailed  = "failed"
+)
+
+var exchangeContentTypes = map[string]string{
+	formatJSONL: "application/x-ndjson",
+	formatJSON:  "application/json",
+	formatYAML:  "application/yaml",
+}
+
+// importRecord is decoded record along with its position in the input
+type importRecord struct {
+	line int
+	rec  *ConfigRecord
+	err  error
+}
+
+// parseExchangeFormat checks requested format, jsonl is the default
+func parseExchangeFormat(format string) (string, error) {
+	if format == "" {
+		return formatJSONL, nil
+	}
+	if _, ok := exchangeContentTypes[format]; !ok {
+		return "", fmt.Errorf("unsupported format %q, expected one of jsonl, json, yaml", format)
+	}
+	return format, nil
+}
+
+// parseImportMode checks requested import mode, merge is the default
+func parseImportMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return importModeMerge, nil
+	case importModeMerge, importModeReplace, importModeSkip:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported mode %q, expected one of merge, replace, skip", mode)
+}
+
+// exportConfigs writes every config include accepts to w in given format, optionally along with revision history,
+// secret values are redacted for configs reveal rejects, nil functions accept everything
+func (srv *WebServer) exportConfigs(w io.Writer, format string, history bool, include, reveal func(name string) bool) error {
+	cfgs, err := srv.store.GetConfigs()
+	if err != nil {
+		return err
+	}
+
+	enc := newRecordEncoder(w, format)
+	for _, cfg := range *cfgs {
+		if include != nil && !include(cfg.Name) {
+			continue
+		}
+		rec := ConfigRecord{Config: cfg}
+		if history {
+			revs, err := srv.store.GetConfigHistory(cfg.Name)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if revs != nil {
+				rec.History = *revs
+			}
+		}
+		if reveal != nil && !reveal(cfg.Name) {
+			rec.Metadata = redactMetadata(rec.Metadata)
+			for idx := range rec.History {
+				rec.History[idx].Metadata = redactMetadata(rec.History[idx].Metadata)
+			}
+		}
+		if err := enc.encode(&rec); err != nil {
+			return err
+		}
+	}
+	return enc.close()
+}
+
+// recordEncoder streams records in one of interchange formats
+type recordEncoder struct {
+	w      io.Writer
+	format string
+	count  int
+	yaml   *yaml.Encoder
+}
+
+func newRecordEncoder(w io.Writer, format string) *recordEncoder {
+	enc := &recordEncoder{w: w, format: format}
+	if format == formatYAML {
+		enc.yaml = yaml.NewEncoder(w)
+	}
+	return enc
+}
+
+// encode writes single record, yaml records are separate documents of one stream
+func (enc *recordEncoder) encode(rec *ConfigRecord) error {
+	defer func() { enc.count++ }()
+
+	buf, err := marshalRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	switch enc.format {
+	case formatYAML:
+		var v interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return err
+		}
+		return enc.yaml.Encode(v)
+	case formatJSON:
+		prefix := ","
+		if enc.count == 0 {
+			prefix = "["
+		}
+		_, err = fmt.Fprintf(enc.w, "%s%s", prefix, buf)
+		return err
+	default:
+		_, err = fmt.Fprintf(enc.w, "%s\n", buf)
+		return err
+	}
+}
+
+// close finishes the stream
+func (enc *recordEncoder) close() error {
+	switch enc.format {
+	case formatYAML:
+		return enc.yaml.Close()
+	case formatJSON:
+		closing := "]\n"
+		if enc.count == 0 {
+			closing = "[]\n"
+		}
+		_, err := io.WriteString(enc.w, closing)
+		return err
+	}
+	return nil
+}
+
+// marshalRecord encodes record as compact json keeping metadata characters as they are
+func marshalRecord(rec *ConfigRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// decodeRecords reads records in given format, malformed records are reported individually,
+// only input which cannot be split into records at all fails the whole import
+func decodeRecords(r io.Reader, format string) ([]importRecord, error) {
+	var recs []importRecord
+
+	switch format {
+	case formatJSON:
+		var raws []json.RawMessage
+		if err := json.NewDecoder(r).Decode(&raws); err != nil {
+			return nil, err
+		}
+		for idx, raw := range raws {
+			recs = append(recs, unmarshalRecord(idx+1, raw))
+		}
+	case formatYAML:
+		dec := yaml.NewDecoder(r)
+		for line := 1; ; line++ {
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, err
+			}
+			raw, err := json.Marshal(v)
+			if err != nil {
+				recs = append(recs, importRecord{line: line, err: err})
+				continue
+			}
+			recs = append(recs, unmarshalRecord(line, raw))
+		}
+	default:
+		br := bufio.NewReader(r)
+		for line := 1; ; line++ {
+			raw, err := br.ReadBytes('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(raw)) > 0 {
+				recs = append(recs, unmarshalRecord(line, raw))
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	return recs, nil
+}
+
+// unmarshalRecord decodes single json record
+func unmarshalRecord(line int, raw []byte) importRecord {
+	rec := &ConfigRecord{}
+	if err := json.Unmarshal(raw, rec); err != nil {
+		return importRecord{line: line, err: err}
+	}
+	return importRecord{line: line, rec: rec}
+}
+
+// importConfigs applies records in a single transaction of store, failed records are rolled back to their own savepoint
+// and reported along with the rest, history of records is informational and is not imported,
+// progress is called after every record when set
+func (srv *WebServer) importConfigs(store DatabaseStore, recs []importRecord, mode string, dryRun bool, progress func(ImportResult)) (*ImportResponse, error) {
+	resp := &ImportResponse{DryRun: dryRun, Results: make([]ImportResult, 0, len(recs))}
+
+	err := store.Batch(func(tx StoreTx) error {
+		for _, rec := range recs {
+			if err := tx.Savepoint("import_record"); err != nil {
+				return err
+			}
+
+			result, err := srv.applyImportRecord(tx, rec, mode)
+			if err != nil {
+				return err
+			}
+
+			if result.Status == importFailed {
+				if err := tx.RollbackTo("import_record"); err != nil {
+					return err
+				}
+			}
+			if err := tx.Release("import_record"); err != nil {
+				return err
+			}
+
+			resp.add(*result)
+			if progress != nil {
+				progress(*result)
+			}
+		}
+		if dryRun {
+			return errBatchDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchDryRun) {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// add records result and updates counters
+func (resp *ImportResponse) add(result ImportResult) {
+	switch result.Status {
+	case importCreated:
+		resp.Created++
+	case importUpdated:
+		resp.Updated++
+	case importSkipped:
+		resp.Skipped++
+	default:
+		resp.Failed++
+	}
+	resp.Results = append(resp.Results, result)
+}
+
+// applyImportRecord validates and stores single record, failures are reported through result
+func (srv *WebServer) applyImportRecord(tx StoreTx, rec importRecord, mode string) (*ImportResult, error) {
+	result := &ImportResult{Line: rec.line}
+
+	fail := func(err error) (*ImportResult, error) {
+		result.Status = importFailed
+		result.Error = err.Error()
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			result.Error = verr.Message
+			result.Fields = verr.Fields
+		}
+		return result, nil
+	}
+
+	if rec.err != nil {
+		return fail(rec.err)
+	}
+	result.Name = rec.rec.Name
+
+	if verr := validateConfigName(rec.rec.Name); verr != nil {
+		return fail(verr)
+	}
+
+	current, err := tx.GetConfigByName(rec.rec.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	exists := current != nil
+
+	if exists && mode == importModeSkip {
+		result.Status = importSkipped
+		return result, nil
+	}
+
+	cfg := &Config{Name: rec.rec.Name, Metadata: rec.rec.Metadata, Schema: rec.rec.Schema, Extends: rec.rec.Extends, Labels: rec.rec.Labels}
+	if exists && mode == importModeMerge {
+		if cfg.Metadata, err = mergeMetadata(current.Metadata, rec.rec.Metadata); err != nil {
+			return nil, err
+		}
+		keepDeclarations(cfg, current)
+	}
+
+	verr, err := srv.validateConfigWith(tx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if verr != nil {
+		return fail(verr)
+	}
+
+	if exists {
+		if err := tx.UpdateConfigByName(cfg.Name, cfg); err != nil {
+			return nil, err
+		}
+		result.Status = importUpdated
+		return result, nil
+	}
+
+	if _, err := tx.InsertConfig(cfg); err != nil {
+		if errors.Is(err, ErrConfigExists) {
+			return fail(err)
+		}
+		return nil, err
+	}
+	result.Status = importCreated
+	return result, nil
+}
+
+// exportGetHandler handles GET /export
+func (srv *WebServer) exportGetHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := parseExchangeFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", exchangeContentTypes[format])
+
+	// response is streamed, errors past this point can only be logged
+	if err := srv.exportConfigs(w, format, isTrue(r.URL.Query().Get("history")), srv.readable(r), srv.revealable(r)); err != nil {
+		srv.log.Info("Error exporting configs", zap.Error(err))
+	}
+}
+
+// importPostHandler handles POST /import
+func (srv *WebServer) importPostHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := parseExchangeFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode, err := parseImportMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recs, err := decodeRecords(r.Body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// import is all or nothing as far as permissions go
+	for _, rec := range recs {
+		if rec.rec == nil {
+			continue
+		}
+		if !srv.authorize(w, r, rec.rec.Name, roleEditor) {
+			return
+		}
+		srv.auditTouch(r, rec.rec.Name)
+	}
+
+	resp, err := srv.importConfigs(srv.storeFor(r), recs, mode, isDryRun(r), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+
+	initDB := func(t *testing.T) InitializerFunc {
+		return func(db *Database) error {
+			createTable(t, db)
+			if _, err := db.InsertConfig(&Config{Name: "base", Metadata: &Metadata{"a": "1", "b": "2"}}); err != nil {
+				return err
+			}
+			_, err := db.InsertConfig(&Config{Name: "other", Metadata: &Metadata{"x": "1"}})
+			return err
+		}
+	}
+
+	t.Run("export", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodGet,
+				path:   "/export",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"base","metadata":{"a":"1","b":"2"}}`+"\n"+`{"id":2,"name":"other","metadata":{"x":"1"}}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/export?format=json&history=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var recs []ConfigRecord
+					if err := json.Unmarshal(res.Body.Bytes(), &recs); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if len(recs) != 2 || len(recs[0].History) != 1 {
+						t.Errorf("unexpected export %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/export?format=yaml",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), "id: 1\nmetadata:\n    a: \"1\"\n    b: \"2\"\nname: base\n---\nid: 2\nmetadata:\n    x: \"1\"\nname: other")
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/export?format=xml",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusBadRequest)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+	t.Run("import", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		lines := strings.Join([]string{
+			`{"name":"base","metadata":{"b":null,"c":"3"}}`,
+			`{"name":"new-one","metadata":{"y":"1"}}`,
+			`not a record`,
+			``,
+			`{"name":"Bad Name","metadata":{}}`,
+		}, "\n")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/import",
+				body:   strings.NewReader(lines),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+
+					var resp ImportResponse
+					if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if resp.Created != 1 || resp.Updated != 1 || resp.Failed != 2 || resp.Results[3].Line != 5 {
+						t.Errorf("unexpected import report %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/import?format=yaml&mode=skip",
+				body:   strings.NewReader("name: other\nmetadata:\n  x: \"2\"\n---\nname: third\nmetadata:\n  z: \"1\"\n"),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var resp ImportResponse
+					if err := json.Unmarshal(res.Body.Bytes(), &resp); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if resp.Created != 1 || resp.Skipped != 1 {
+						t.Errorf("unexpected import report %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/import?format=json&mode=replace",
+				body:   strings.NewReader(`[{"name":"new-one","metadata":{"w":"1"}}]`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":1,"name":"base","metadata":{"a":"1","c":"3"}},{"id":2,"name":"other","metadata":{"x":"1"}},{"id":3,"name":"new-one","metadata":{"w":"1"}},{"id":4,"name":"third","metadata":{"z":"1"}}]`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -30,11 +31,37 @@ func (srv *WebServer) defaultGetHandler(w http.ResponseWriter, r *http.Request)
 
 // configsGetAllHandler handles GET /configs
 func (srv *WebServer) configsGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	if err := srv.waitForChanges(r); err != nil {
+		writeBlockingError(w, err)
+		return
+	}
+
+	// index is taken before reading so no change goes unnoticed by the next blocking query
+	srv.setChangesIndex(w)
+
 	cfgs, err := srv.store.GetConfigs()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	cfgs = srv.filterConfigs(r, cfgs)
+
+	// configs failing to render are listed with the reason rather than failing the whole list
+	if isTrue(r.URL.Query().Get("render")) {
+		items, err := srv.renderConfigs(r, cfgs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(items); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	cfgs = srv.redactConfigs(r, cfgs)
 
 	w.Header().Set("Content-Type", "application/json")
 
@@ -47,12 +74,55 @@ func (srv *WebServer) configsGetAllHandler(w http.ResponseWriter, r *http.Reques
 // configsPostHandler handles POST /configs
 func (srv *WebServer) configsPostHandler(w http.ResponseWriter, r *http.Request) {
 	var cfg Config
+	srv.metadata.limitBody(w, r, 1)
 	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
+		return
+	}
+
+	if verr := validateConfigName(cfg.Name); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if !srv.authorize(w, r, cfg.Name, roleEditor) {
+		return
+	}
+	srv.auditTouch(r, cfg.Name)
+
+	verr, err := srv.validateConfig(&cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if isDryRun(r) {
+		current, err := srv.store.GetConfigByName(cfg.Name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if current != nil {
+			http.Error(w, ErrConfigExists.Error(), http.StatusConflict)
+			return
+		}
+		writeDryRun(w, http.StatusOK, nil, &cfg)
+		return
+	}
+
+	if _, err := srv.storeFor(r).InsertConfig(&cfg); err != nil {
+		if errors.Is(err, ErrConfigExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	srv.store.InsertConfig(&cfg)
 	fmt.Fprint(w, "new configuration item has successfully been added")
 }
 
@@ -64,6 +134,18 @@ func (srv *WebServer) configsGetOneHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := srv.waitForConfig(r, name); err != nil {
+		writeBlockingError(w, err)
+		return
+	}
+
+	// read before config so that index is never newer than what is returned
+	envIndex, hasEnv, err := srv.envIndex(r, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	cfg, err := srv.store.GetConfigByName(name)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -74,20 +156,117 @@ func (srv *WebServer) configsGetOneHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// effective metadata with parents merged in and placeholders expanded
+	cfg, err = srv.presentConfig(r, cfg)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, verr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Config-Revision", strconv.Itoa(cfg.Revision))
+	if hasEnv {
+		w.Header().Set("X-Config-Index", strconv.FormatUint(envIndex, 10))
+	} else {
+		w.Header().Set("X-Config-Index", strconv.Itoa(cfg.Revision))
+	}
+
+	if err := json.NewEncoder(w).Encode(srv.redactConfig(r, cfg)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
+
+// configsPutHandler handles PUT /configs/abc, creates config if missing or replaces it entirely,
+// schema of existing config is kept unless body sets it, empty string removes it
+func (srv *WebServer) configsPutHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Config
+		Schema *string `json:"schema"`
+	}
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// name in path is authoritative
+	cfg := body.Config
+	cfg.Name = mux.Vars(r)["name"]
 
-	if err := json.NewEncoder(w).Encode(cfg); err != nil {
+	current, err := srv.store.GetConfigByName(cfg.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	switch {
+	case body.Schema != nil:
+		cfg.Schema = *body.Schema
+	case current != nil:
+		cfg.Schema = current.Schema
+	}
+
+	// configs named before names were validated stay updatable
+	if current == nil {
+		if verr := validateConfigName(cfg.Name); verr != nil {
+			writeValidationError(w, verr)
+			return
+		}
+	}
+
+	verr, err := srv.validateConfig(&cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if isDryRun(r) {
+		if current == nil {
+			writeDryRun(w, http.StatusCreated, nil, &cfg)
+			return
+		}
+		cfg.ID = current.ID
+		writeDryRun(w, http.StatusOK, current, &cfg)
+		return
+	}
+
+	created, err := srv.storeFor(r).UpsertConfig(&cfg)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrConfigExists), errors.Is(err, ErrConfigInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "new configuration item has successfully been added")
+		return
+	}
+
+	fmt.Fprint(w, "new configuration item has successfully been updated")
 }
 
 // configsUpdateOneHandler handles PATCH /configs/abc
 func (srv *WebServer) configsUpdateOneHandler(w http.ResponseWriter, r *http.Request) {
 	var cfg Config
+	srv.metadata.limitBody(w, r, 1)
 	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
 
@@ -97,7 +276,52 @@ func (srv *WebServer) configsUpdateOneHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	srv.store.UpdateConfigByName(name, &cfg)
+	current, err := srv.store.GetConfigByName(name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current == nil {
+		http.Error(w, "configuration item was not found", http.StatusNotFound)
+		return
+	}
+
+	// keep declared schema, parents and labels unless request replaces them
+	if cfg.Schema == "" {
+		cfg.Schema = current.Schema
+	}
+	if cfg.Extends == nil {
+		cfg.Extends = current.Extends
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = current.Labels
+	}
+	cfg.Name = name
+
+	verr, err := srv.validateConfig(&cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if isDryRun(r) {
+		cfg.ID = current.ID
+		writeDryRun(w, http.StatusOK, current, &cfg)
+		return
+	}
+
+	if err := srv.storeFor(r).UpdateConfigByName(name, &cfg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	fmt.Fprint(w, "new configuration item has successfully been updated")
 }
@@ -110,7 +334,16 @@ func (srv *WebServer) configsDeleteOneHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if err := srv.store.DeleteConfigByName(name); err != nil {
+	if isDryRun(r) {
+		srv.dryRunDelete(w, name)
+		return
+	}
+
+	if err := srv.storeFor(r).DeleteConfigByName(name); err != nil {
+		if errors.Is(err, ErrConfigInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -118,9 +351,172 @@ func (srv *WebServer) configsDeleteOneHandler(w http.ResponseWriter, r *http.Req
 	fmt.Fprint(w, "configuration item has successfully been erased")
 }
 
-// searchGetHandler handles GET /search
-func (srv *WebServer) searchGetHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Search GET!")
+// configsRenameHandler handles POST /configs/abc/rename
+func (srv *WebServer) configsRenameHandler(w http.ResponseWriter, r *http.Request) {
+	var target struct {
+		Name string `json:"name"`
+	}
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if verr := validateConfigName(target.Name); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if !srv.authorize(w, r, target.Name, roleEditor) {
+		return
+	}
+	srv.auditTouch(r, target.Name)
+
+	name := mux.Vars(r)["name"]
+
+	if err := srv.storeFor(r).RenameConfig(name, target.Name); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+		case errors.Is(err, ErrConfigExists), errors.Is(err, ErrConfigInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	fmt.Fprint(w, "configuration item has successfully been renamed")
+}
+
+// configsCloneHandler handles POST /configs/abc/clone
+func (srv *WebServer) configsCloneHandler(w http.ResponseWriter, r *http.Request) {
+	var target struct {
+		Name     string    `json:"name"`
+		Metadata *Metadata `json:"metadata"`
+	}
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if verr := validateConfigName(target.Name); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if !srv.authorize(w, r, target.Name, roleEditor) {
+		return
+	}
+	srv.auditTouch(r, target.Name)
+
+	source, err := srv.store.GetConfigByName(mux.Vars(r)["name"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// optional overlay is merged on top of source metadata
+	metadata, err := mergeMetadata(source.Metadata, target.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cfg := &Config{Name: target.Name, Metadata: metadata, Schema: source.Schema, Extends: source.Extends, Labels: source.Labels}
+
+	verr, err := srv.validateConfig(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	if _, err := srv.storeFor(r).CloneConfig(source, cfg); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+		case errors.Is(err, ErrConfigExists), errors.Is(err, ErrRevisionMismatch):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	fmt.Fprint(w, "configuration item has successfully been cloned")
+}
+
+// configsDependentsHandler handles GET /configs/abc/dependents
+func (srv *WebServer) configsDependentsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if _, err := srv.store.GetConfigByName(name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	names, err := srv.store.GetConfigDependents(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// dependents caller cannot read are left out
+	visible := []string{}
+	for _, dep := range *names {
+		if srv.allowed(r, dep, roleReader) {
+			visible = append(visible, dep)
+		}
+	}
+	names = &visible
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(names); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// configsHistoryHandler handles GET /configs/abc/history
+func (srv *WebServer) configsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	revs, err := srv.store.GetConfigHistory(name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !srv.revealed(r, name) {
+		for idx := range *revs {
+			(*revs)[idx].Metadata = redactMetadata((*revs)[idx].Metadata)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(revs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 // initRoutes creates router for server
@@ -128,11 +524,52 @@ func (srv *WebServer) initRoutes() {
 	router := mux.NewRouter()
 	router.HandleFunc("/", srv.defaultGetHandler).Methods("GET")
 	router.HandleFunc("/healthz", srv.healthGetHandler).Methods("GET")
+	router.HandleFunc("/metrics", srv.metricsGetHandler).Methods("GET")
 	router.HandleFunc("/configs", srv.configsGetAllHandler).Methods("GET")
 	router.HandleFunc("/configs", srv.configsPostHandler).Methods("POST")
-	router.HandleFunc("/configs/{name}", srv.configsGetOneHandler).Methods("GET")
-	router.HandleFunc("/configs/{name}", srv.configsUpdateOneHandler).Methods("PUT", "PATCH")
-	router.HandleFunc("/configs/{name}", srv.configsDeleteOneHandler).Methods("DELETE")
+	router.HandleFunc("/configs:batch", srv.configsBatchHandler).Methods("POST")
+	router.HandleFunc("/configs/{name}", srv.requireRole(roleReader, srv.configsGetOneHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}", srv.requireRole(roleEditor, srv.configsPutHandler)).Methods("PUT")
+	router.HandleFunc("/configs/{name}", srv.requireRole(roleEditor, srv.configsUpdateOneHandler)).Methods("PATCH")
+	router.HandleFunc("/configs/{name}", srv.requireRole(roleEditor, srv.configsDeleteOneHandler)).Methods("DELETE")
+	router.HandleFunc("/configs/{name}/rename", srv.requireRole(roleEditor, srv.configsRenameHandler)).Methods("POST")
+	router.HandleFunc("/configs/{name}/clone", srv.requireRole(roleReader, srv.configsCloneHandler)).Methods("POST")
+	router.HandleFunc("/configs/{name}/dependents", srv.requireRole(roleReader, srv.configsDependentsHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/history", srv.requireRole(roleReader, srv.configsHistoryHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/watch", srv.requireRole(roleReader, srv.configsWatchHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/overlays", srv.requireRole(roleReader, srv.overlaysGetAllHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/overlays/{env}", srv.requireRole(roleReader, srv.overlaysGetOneHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/overlays/{env}", srv.requireRole(roleEditor, srv.overlaysPutHandler)).Methods("PUT", "PATCH")
+	router.HandleFunc("/configs/{name}/overlays/{env}", srv.requireRole(roleEditor, srv.overlaysDeleteOneHandler)).Methods("DELETE")
+	router.HandleFunc("/configs/{name}/overlays/{env}/history", srv.requireRole(roleReader, srv.overlaysHistoryHandler)).Methods("GET")
+	router.HandleFunc("/configs/{name}/schedules", srv.requireRole(roleEditor, srv.schedulesPostHandler)).Methods("POST")
+	router.HandleFunc("/schedules", srv.schedulesGetAllHandler).Methods("GET")
+	router.HandleFunc("/schedules/{id}", srv.schedulesDeleteOneHandler).Methods("DELETE")
+	router.HandleFunc("/admin/backup", srv.requireRole(roleAdmin, srv.adminBackupPostHandler)).Methods("POST")
+	router.HandleFunc("/admin/secrets/rotate", srv.requireRole(roleAdmin, srv.secretsRotatePostHandler)).Methods("POST")
+	router.HandleFunc("/admin/apikeys", srv.requireRole(roleAdmin, srv.apiKeysGetAllHandler)).Methods("GET")
+	router.HandleFunc("/admin/apikeys", srv.requireRole(roleAdmin, srv.apiKeysPostHandler)).Methods("POST")
+	router.HandleFunc("/admin/apikeys/{id:[0-9]+}", srv.requireRole(roleAdmin, srv.apiKeysDeleteOneHandler)).Methods("DELETE")
+	router.HandleFunc("/admin/rolebindings", srv.requireRole(roleAdmin, srv.roleBindingsGetAllHandler)).Methods("GET")
+	router.HandleFunc("/admin/rolebindings", srv.requireRole(roleAdmin, srv.roleBindingsPostHandler)).Methods("POST")
+	router.HandleFunc("/admin/rolebindings/{id:[0-9]+}", srv.requireRole(roleAdmin, srv.roleBindingsDeleteOneHandler)).Methods("DELETE")
+	router.HandleFunc("/changes", srv.changesGetHandler).Methods("GET")
+	router.HandleFunc("/audit", srv.requireRole(roleAdmin, srv.auditGetHandler)).Methods("GET")
+	router.HandleFunc("/webhooks", srv.requireRole(roleAdmin, srv.webhooksGetAllHandler)).Methods("GET")
+	router.HandleFunc("/webhooks", srv.requireRole(roleAdmin, srv.webhooksPostHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/deliveries", srv.requireRole(roleAdmin, srv.webhooksDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{id:[0-9]+}/retry", srv.requireRole(roleAdmin, srv.webhooksRetryHandler)).Methods("POST")
+	router.HandleFunc("/webhooks/{id:[0-9]+}", srv.requireRole(roleAdmin, srv.webhooksGetOneHandler)).Methods("GET")
+	router.HandleFunc("/webhooks/{id:[0-9]+}", srv.requireRole(roleAdmin, srv.webhooksDeleteOneHandler)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", srv.requireRole(roleAdmin, srv.webhooksDeliveriesHandler)).Methods("GET")
+	router.HandleFunc("/export", srv.exportGetHandler).Methods("GET")
+	router.HandleFunc("/import", srv.importPostHandler).Methods("POST")
+	router.HandleFunc("/schemas", srv.schemasGetAllHandler).Methods("GET")
+	router.HandleFunc("/schemas/{name}", srv.schemasGetOneHandler).Methods("GET")
+	router.HandleFunc("/schemas/{name}", srv.requireRole(roleAdmin, srv.schemasPostHandler)).Methods("POST")
+	router.HandleFunc("/watch", srv.watchGetHandler).Methods("GET")
+	router.HandleFunc("/ws", srv.wsGetHandler).Methods("GET")
 	router.HandleFunc("/search", srv.searchGetHandler).Methods("GET")
-	srv.Handler = router
+	router.Use(srv.authMiddleware, srv.dryRunMiddleware, srv.auditMiddleware)
+	srv.Handler = srv.withRequestLog(router)
 }
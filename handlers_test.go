@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -19,6 +21,22 @@ type TestSubmitSequenceRequest struct {
 	verifier func(*testing.T, *httptest.ResponseRecorder)
 }
 
+type stubTx struct {
+	*DatabaseStub
+}
+
+func (t *stubTx) Savepoint(name string) error {
+	return nil
+}
+
+func (t *stubTx) RollbackTo(name string) error {
+	return nil
+}
+
+func (t *stubTx) Release(name string) error {
+	return nil
+}
+
 type DatabaseStub struct {
 	Connected bool
 	Config    []Config
@@ -64,6 +82,206 @@ func (d *DatabaseStub) UpdateConfigByName(name string, newCfg *Config) error {
 	return nil
 }
 
+func (d *DatabaseStub) InsertScheduledChange(chg *ScheduledChange) (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) GetScheduledChanges(name string) (*[]ScheduledChange, error) {
+	return &[]ScheduledChange{}, nil
+}
+
+func (d *DatabaseStub) GetScheduledChange(id int) (*ScheduledChange, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) CancelScheduledChange(id int) error {
+	return nil
+}
+
+func (d *DatabaseStub) ApplyScheduledChanges(now time.Time, validate configValidator) (*[]ScheduledChange, error) {
+	return &[]ScheduledChange{}, nil
+}
+
+func (d *DatabaseStub) InsertSchema(s *Schema) error {
+	return nil
+}
+
+func (d *DatabaseStub) GetSchema(name string) (*Schema, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) GetSchemas() (*[]Schema, error) {
+	return &[]Schema{}, nil
+}
+
+func (d *DatabaseStub) RenameConfig(name, newName string) error {
+	for idx, cfg := range d.Config {
+		if cfg.Name == name {
+			d.Config[idx].Name = newName
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) GetConfigHistory(name string) (*[]ConfigRevision, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) CloneConfig(source *Config, cfg *Config) (int, error) {
+	clone := *cfg
+	clone.ClonedFrom = source.Name
+	clone.ClonedRevision = source.Revision
+	return d.InsertConfig(&clone)
+}
+
+func (d *DatabaseStub) GetConfigDependents(name string) (*[]string, error) {
+	return &[]string{}, nil
+}
+
+func (d *DatabaseStub) PutOverlay(ovr *Overlay) (bool, error) {
+	return true, nil
+}
+
+func (d *DatabaseStub) GetOverlay(name, env string) (*Overlay, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) GetOverlays(name, env string) (*[]Overlay, error) {
+	return &[]Overlay{}, nil
+}
+
+func (d *DatabaseStub) DeleteOverlay(name, env string) error {
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) GetOverlayHistory(name, env string) (*[]ConfigRevision, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) Batch(fn func(tx StoreTx) error) error {
+	return fn(&stubTx{d})
+}
+
+func (d *DatabaseStub) Backup(path string) error {
+	return nil
+}
+
+func (d *DatabaseStub) InsertWebhook(hook *Webhook) (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) GetWebhooks() (*[]Webhook, error) {
+	return &[]Webhook{}, nil
+}
+
+func (d *DatabaseStub) GetWebhook(id int) (*Webhook, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) DeleteWebhook(id int) error {
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) EnqueueDeliveries(seq uint64, dls []WebhookDelivery) error {
+	return nil
+}
+
+func (d *DatabaseStub) GetDueDeliveries(now time.Time, limit int) (*[]WebhookDelivery, error) {
+	return &[]WebhookDelivery{}, nil
+}
+
+func (d *DatabaseStub) UpdateDelivery(dl *WebhookDelivery) error {
+	return nil
+}
+
+func (d *DatabaseStub) GetDeliveries(webhookID int, status string) (*[]WebhookDelivery, error) {
+	return &[]WebhookDelivery{}, nil
+}
+
+func (d *DatabaseStub) RetryDelivery(id int, now time.Time) error {
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) GetChanges(since uint64, limit int) (*[]ConfigEvent, error) {
+	return &[]ConfigEvent{}, nil
+}
+
+func (d *DatabaseStub) LastChangeSeq() (uint64, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) LastChangeSeqOf(name string) (uint64, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) Audited(scope *auditScope) DatabaseStore {
+	return d
+}
+
+func (d *DatabaseStub) GetChangeCursor(consumer string) (uint64, error) {
+	return 0, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) AdvanceChangeCursor(consumer string, seq uint64) error {
+	return nil
+}
+
+func (d *DatabaseStub) ExpireChangeCursors(prefix string, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) InsertAPIKey(key *APIKey) (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) GetAPIKeys() (*[]APIKey, error) {
+	return &[]APIKey{}, nil
+}
+
+func (d *DatabaseStub) GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *DatabaseStub) RevokeAPIKey(id int) error {
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) InsertRoleBinding(b *RoleBinding) (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) GetRoleBindings() (*[]RoleBinding, error) {
+	return &[]RoleBinding{}, nil
+}
+
+func (d *DatabaseStub) DeleteRoleBinding(id int) error {
+	return sql.ErrNoRows
+}
+
+func (d *DatabaseStub) RotateSecrets() (int, error) {
+	return 0, nil
+}
+
+func (d *DatabaseStub) InsertAuditEntry(entry *AuditEntry) error {
+	return nil
+}
+
+func (d *DatabaseStub) GetAuditEntries(name string, since time.Time, limit int) (*[]AuditEntry, error) {
+	return &[]AuditEntry{}, nil
+}
+
+func (d *DatabaseStub) UpsertConfig(cfg *Config) (bool, error) {
+	for idx := range d.Config {
+		if d.Config[idx].Name == cfg.Name {
+			d.Config[idx].Metadata = cfg.Metadata
+			return false, nil
+		}
+	}
+	_, err := d.InsertConfig(cfg)
+	return true, err
+}
+
 func (d *DatabaseStub) IsConnected() bool {
 	return d.Connected
 }
@@ -129,17 +347,78 @@ func prepareRequest(t *testing.T, method, path string, body io.Reader) (*http.Re
 	return req, res
 }
 
+// testRemoteAddr is address requests served by getResponse come from
+const testRemoteAddr = "192.0.2.10:51000"
+
+// authEnabled is environment of servers requiring authentication
+var authEnabled = map[string]string{"SERVE_AUTH_ENABLED": "true"}
+
+// newTestServer prepares server backed by in-memory database while env is set, seed functions fill the
+// database before server starts, database is returned for checks of rows the way they are stored
+func newTestServer(t *testing.T, env map[string]string, seed ...InitializerFunc) (*WebServer, *Database) {
+	t.Helper()
+
+	t.Setenv("SERVE_PORT", "8080")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	memStore, cleanUp, err := NewMemDatabaseStore(func(db *Database) error {
+		createTable(t, db)
+		for _, fn := range seed {
+			if err := fn(db); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	t.Cleanup(cleanUp)
+
+	srv, err := NewWebServer(memStore)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return srv, memStore
+}
+
+// getResponse serves request with srv and returns recorded response, header lists names followed by values
+func getResponse(t *testing.T, srv *WebServer, method, path string, body io.Reader, header ...string) *http.Response {
+	t.Helper()
+
+	req, res := prepareRequest(t, method, path, body)
+	req.RemoteAddr = testRemoteAddr
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	srv.Handler.ServeHTTP(res, req)
+	return res.Result()
+}
+
+// readBody reads whole response body
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+
+	buf, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return string(buf)
+}
+
 func createTable(t *testing.T, db *Database) {
 	t.Helper()
 
 	stmt := `
-		CREATE TABLE configs (
+		CREATE TABLE IF NOT EXISTS configs (
 			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 			name VARCHAR(255) NOT NULL,
 			metadata TEXT NOT NULL,
 			created_at DATETIME NOT NULL
 		);
-		CREATE INDEX idx_configs_created ON configs(created_at);
+		CREATE INDEX IF NOT EXISTS idx_configs_created ON configs(created_at);
 		`
 
 	_, err := db.Exec(stmt)
@@ -448,6 +727,80 @@ func TestGetSearch(t *testing.T) {
 		assertResponseBody(t, got, want)
 	})
 
+	t.Run("filters", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			cfgs := []*Config{
+				{Name: "datacenter-1", Metadata: &Metadata{"monitoring": map[string]string{"enabled": "true"}, "limits": map[string]interface{}{"cpu": map[string]string{"enabled": "false", "value": "300m"}}}},
+				{Name: "datacenter-2", Metadata: &Metadata{"monitoring": map[string]string{"enabled": "true"}, "limits": map[string]interface{}{"cpu": map[string]string{"enabled": "true", "value": "250m"}}}},
+				{Name: "burger-nutrition", Metadata: &Metadata{"calories": 230, "allergens": map[string]string{"eggs": "true"}}},
+			}
+			for _, cfg := range cfgs {
+				if _, err := db.InsertConfig(cfg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodGet,
+				path:   "/search?metadata.monitoring.enabled=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `[{"id":1,"name":"datacenter-1","metadata":{"limits":{"cpu":{"enabled":"false","value":"300m"}},"monitoring":{"enabled":"true"}}},{"id":2,"name":"datacenter-2","metadata":{"limits":{"cpu":{"enabled":"true","value":"250m"}},"monitoring":{"enabled":"true"}}}]`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/search?metadata.monitoring.enabled=true&metadata.limits.cpu.value=250m",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":2,"name":"datacenter-2","metadata":{"limits":{"cpu":{"enabled":"true","value":"250m"}},"monitoring":{"enabled":"true"}}}]`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/search?metadata.allergens.eggs=true&metadata.calories=230",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":3,"name":"burger-nutrition","metadata":{"allergens":{"eggs":"true"},"calories":230}}]`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+	t.Run("resolved skips broken", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			cfgs := []*Config{
+				{Name: "base", Metadata: &Metadata{"monitoring": map[string]string{"enabled": "true"}}},
+				{Name: "child", Extends: ConfigNames{"base"}, Metadata: &Metadata{}},
+				{Name: "orphan", Extends: ConfigNames{"base"}, Metadata: &Metadata{}},
+			}
+			for _, cfg := range cfgs {
+				if _, err := db.InsertConfig(cfg); err != nil {
+					return err
+				}
+			}
+			// parent vanished behind the store's back
+			_, err := db.writer.Exec(`UPDATE configs SET extends = '["missing"]' WHERE name = 'orphan'`)
+			return err
+		})
+
+		res := getResponse(t, srv, http.MethodGet, "/search?resolved=true&metadata.monitoring.enabled=true", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if names := configNames(t, res); names != "base,child" {
+			t.Errorf("expected base,child but got %s", names)
+		}
+	})
+
 }
 
 func TestPatchConfigsOne(t *testing.T) {
@@ -592,3 +945,362 @@ func TestDeleteConfigsOne(t *testing.T) {
 	})
 
 }
+
+func TestPostConfigsInvalidName(t *testing.T) {
+
+	names := []string{"", "Upper", "with space", "a/b", "-dash", strings.Repeat("a", 64)}
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			os.Setenv("SERVE_PORT", "8080")
+			defer os.Unsetenv("SERVE_PORT")
+
+			body := strings.NewReader(`{"name":"` + name + `","metadata":{}}`)
+
+			req, res := prepareRequest(t, http.MethodPost, "/configs", body)
+
+			submitRequestInMem(t, nil, req, res)
+
+			assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+		})
+	}
+
+}
+
+func TestRenameConfigsOne(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			if _, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"key": "one"}}); err != nil {
+				return err
+			}
+			if err := db.UpdateConfigByName("abc", &Config{Metadata: &Metadata{"key": "two"}}); err != nil {
+				return err
+			}
+			_, err := db.InsertConfig(&Config{Name: "taken", Metadata: &Metadata{}})
+			return err
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs/abc/rename",
+				body:   strings.NewReader(`{"name":"taken"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/abc/rename",
+				body:   strings.NewReader(`{"name":"Bad Name"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/abc/rename",
+				body:   strings.NewReader(`{"name":"xyz"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), "configuration item has successfully been renamed")
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/xyz",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"xyz","metadata":{"key":"two"}}`)
+					if got := res.Header().Get("X-Config-Revision"); got != "2" {
+						t.Errorf("expected revision %q but got %q", "2", got)
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/xyz/history",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var revs []ConfigRevision
+					if err := json.Unmarshal(res.Body.Bytes(), &revs); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if len(revs) != 2 {
+						t.Fatalf("expected %d revisions but got %d", 2, len(revs))
+					}
+					if revs[0].Revision != 1 || (*revs[0].Metadata)["key"] != "one" {
+						t.Errorf("unexpected first revision %+v", revs[0])
+					}
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/abc",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusNotFound)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		srv, _ := newTestServer(t, map[string]string{"SERVE_METADATA_MAX_SIZE": "1024"})
+
+		huge := `{"name":"` + strings.Repeat("x", 8*1024) + `"}`
+		res := getResponse(t, srv, http.MethodPost, "/configs/abc/rename", strings.NewReader(huge))
+		assertResponseCode(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	})
+
+}
+
+func TestCloneConfigsOne(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			_, err := db.InsertConfig(&Config{Name: "base", Metadata: &Metadata{"monitoring": map[string]string{"enabled": "true"}, "region": "eu"}})
+			return err
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs/base/clone",
+				body:   strings.NewReader(`{"name":"copy","metadata":{"monitoring":{"enabled":"false"},"region":null}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), "configuration item has successfully been cloned")
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/copy",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `{"id":2,"name":"copy","metadata":{"monitoring":{"enabled":"false"}},"cloned_from":"base","cloned_revision":1}`)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/base/clone",
+				body:   strings.NewReader(`{"name":"copy"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/missing/clone",
+				body:   strings.NewReader(`{"name":"another"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusNotFound)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+}
+
+func TestGetConfigsResolved(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			cfgs := []*Config{
+				{Name: "base", Metadata: &Metadata{"monitoring": map[string]string{"enabled": "true"}, "region": "eu"}},
+				{Name: "large", Metadata: &Metadata{"limits": map[string]string{"cpu": "500m"}}},
+				{Name: "dc", Metadata: &Metadata{"region": "us"}, Extends: ConfigNames{"base", "large"}},
+			}
+			for _, cfg := range cfgs {
+				if _, err := db.InsertConfig(cfg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":3,"name":"dc","metadata":{"region":"us"},"extends":["base","large"]}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc?resolved=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":3,"name":"dc","metadata":{"limits":{"cpu":"500m"},"monitoring":{"enabled":"true"},"region":"us"},"extends":["base","large"]}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/search?metadata.limits.cpu=500m&resolved=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var cfgs []Config
+					if err := json.Unmarshal(res.Body.Bytes(), &cfgs); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if len(cfgs) != 2 || cfgs[0].Name != "large" || cfgs[1].Name != "dc" {
+						t.Errorf("unexpected search result %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodPatch,
+				path:   "/configs/base",
+				body:   strings.NewReader(`{"metadata":{},"extends":["dc"]}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+					assertResponseBody(t, res.Body.String(), `{"error":"inheritance cycle detected","fields":[{"field":"extends","message":"base -> dc -> base"}]}`)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs",
+				body:   strings.NewReader(`{"name":"orphan","metadata":{},"extends":["missing"]}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+}
+
+// conflictingStub fails upserts as if concurrent request took the name first
+type conflictingStub struct {
+	DatabaseStub
+}
+
+func (d *conflictingStub) UpsertConfig(cfg *Config) (bool, error) {
+	return false, ErrConfigExists
+}
+
+func (d *conflictingStub) Audited(scope *auditScope) DatabaseStore {
+	return d
+}
+
+func TestPutConfigsOne(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPut,
+				path:   "/configs/abc",
+				body:   strings.NewReader(`{"metadata":{"key":"one"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusCreated)
+					assertResponseBody(t, res.Body.String(), "new configuration item has successfully been added")
+				},
+			},
+			{
+				method: http.MethodPut,
+				path:   "/configs/abc",
+				body:   strings.NewReader(`{"metadata":{"key":"two"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), "new configuration item has successfully been updated")
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/abc",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"abc","metadata":{"key":"two"}}`)
+				},
+			},
+			{
+				method: http.MethodPatch,
+				path:   "/configs/missing",
+				body:   strings.NewReader(`{"metadata":{}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusNotFound)
+				},
+			},
+			{
+				method: http.MethodPut,
+				path:   "/configs/Bad%20Name",
+				body:   strings.NewReader(`{"metadata":{}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, nil, &testPairs)
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			if err := db.InsertSchema(&Schema{Name: "keyed", Document: json.RawMessage(`{"required":["key"]}`)}); err != nil {
+				return err
+			}
+			if _, err := db.InsertConfig(&Config{Name: "abc", Schema: "keyed", Metadata: &Metadata{"key": "one"}}); err != nil {
+				return err
+			}
+			_, err := db.InsertConfig(&Config{Name: "Legacy Name", Metadata: &Metadata{}})
+			return err
+		})
+
+		// schema is kept when omitted
+		res := getResponse(t, srv, http.MethodPut, "/configs/abc", strings.NewReader(`{"metadata":{"other":"two"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		res = getResponse(t, srv, http.MethodPut, "/configs/abc", strings.NewReader(`{"metadata":{"key":"two"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		res = getResponse(t, srv, http.MethodGet, "/configs/abc", nil)
+		assertResponseBody(t, readBody(t, res), `{"id":1,"name":"abc","metadata":{"key":"two"},"schema":"keyed"}`)
+
+		// and removed when cleared explicitly
+		res = getResponse(t, srv, http.MethodPut, "/configs/abc", strings.NewReader(`{"schema":"","metadata":{"other":"two"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		res = getResponse(t, srv, http.MethodGet, "/configs/abc", nil)
+		assertResponseBody(t, readBody(t, res), `{"id":1,"name":"abc","metadata":{"other":"two"}}`)
+
+		// names are validated on create only
+		res = getResponse(t, srv, http.MethodPut, "/configs/Legacy%20Name", strings.NewReader(`{"metadata":{"key":"one"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		req, res := prepareRequest(t, http.MethodPut, "/configs/abc", strings.NewReader(`{"metadata":{}}`))
+
+		submitRequestStub(t, &conflictingStub{DatabaseStub{Connected: true}}, req, res)
+
+		assertResponseCode(t, res.Code, http.StatusConflict)
+		assertResponseBody(t, res.Body.String(), ErrConfigExists.Error())
+	})
+
+}
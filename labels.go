@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelKeyPattern allows optional DNS subdomain prefix followed by slash, e.g. example.com/team
+var labelKeyPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9.-]*[a-z0-9])?/)?[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// labelValuePattern allows empty values as well
+var labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+
+const maxLabelLength = 63
+
+// validateLabels checks keys and values of labels the way names are checked
+func validateLabels(labels StringMap) *ValidationError {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := []FieldError{}
+	for _, k := range keys {
+		field := "labels." + k
+		name := k[strings.LastIndex(k, "/")+1:]
+		switch {
+		case len(name) > maxLabelLength || !labelKeyPattern.MatchString(k):
+			fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf("key must be at most %d alphanumeric characters, '-', '_' or '.', optionally prefixed with DNS subdomain and '/'", maxLabelLength)})
+		case len(labels[k]) > maxLabelLength || !labelValuePattern.MatchString(labels[k]):
+			fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf("value must be at most %d alphanumeric characters, '-', '_' or '.'", maxLabelLength)})
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Message: "invalid labels", Fields: fields}
+}
+
+// labelRequirement is single comma separated term of label selector
+type labelRequirement struct {
+	Key    string
+	Value  string
+	Op     string
+	Exists bool
+}
+
+const (
+	labelOpEquals    = "="
+	labelOpNotEquals = "!="
+	labelOpExists    = ""
+)
+
+// labelSelector matches labels satisfying every requirement, empty selector matches everything
+type labelSelector []labelRequirement
+
+// parseLabelSelector parses equality based selector such as `team=sre,tier!=db,canary,!legacy`,
+// `==` is accepted for `=`, `!=` matches configs lacking the key as well
+func parseLabelSelector(s string) (labelSelector, error) {
+	sel := labelSelector{}
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{Key: parts[0], Value: parts[1], Op: labelOpNotEquals}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			req = labelRequirement{Key: parts[0], Value: parts[1], Op: labelOpEquals}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			req = labelRequirement{Key: parts[0], Value: parts[1], Op: labelOpEquals}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{Key: strings.TrimPrefix(term, "!"), Op: labelOpExists}
+		default:
+			req = labelRequirement{Key: term, Op: labelOpExists, Exists: true}
+		}
+		req.Key, req.Value = strings.TrimSpace(req.Key), strings.TrimSpace(req.Value)
+
+		if verr := validateLabels(StringMap{req.Key: req.Value}); verr != nil {
+			return nil, fmt.Errorf("invalid label selector term %q", term)
+		}
+		sel = append(sel, req)
+	}
+
+	return sel, nil
+}
+
+// matches reports whether labels satisfy every requirement of selector
+func (sel labelSelector) matches(labels StringMap) bool {
+	for _, req := range sel {
+		value, ok := labels[req.Key]
+		switch req.Op {
+		case labelOpEquals:
+			if !ok || value != req.Value {
+				return false
+			}
+		case labelOpNotEquals:
+			if ok && value == req.Value {
+				return false
+			}
+		default:
+			if ok != req.Exists {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLabelSelector(t *testing.T) {
+
+	t.Run("matches", func(t *testing.T) {
+		labels := StringMap{"team": "sre", "tier": "db", "example.com/canary": ""}
+
+		for selector, want := range map[string]bool{
+			"":                         true,
+			"team=sre":                 true,
+			"team==sre":                true,
+			"team=app":                 false,
+			"team!=app":                true,
+			"owner!=app":               true,
+			"team=sre,tier!=db":        false,
+			"example.com/canary":       true,
+			"!example.com/canary":      false,
+			"team = sre , !legacy":     true,
+			"team=sre,tier=db,missing": false,
+			"team==":                   false,
+		} {
+			sel, err := parseLabelSelector(selector)
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", selector, err)
+			}
+			if got := sel.matches(labels); got != want {
+				t.Errorf("%q: expected %v but got %v", selector, want, got)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, selector := range []string{"=sre", "team=a b", "team,,tier", "!", "team in (a,b)"} {
+			if _, err := parseLabelSelector(selector); err == nil {
+				t.Errorf("%q: expected error, none thrown", selector)
+			}
+		}
+	})
+
+	t.Run("validated on write", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil)
+
+		res := getResponse(t, srv, http.MethodPost, "/configs", strings.NewReader(`{"name":"abc","labels":{"bad key":"x"},"metadata":{}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		if body := readBody(t, res); !strings.Contains(body, "labels.bad key") {
+			t.Errorf("expected offending label to be named but got %s", body)
+		}
+
+		res = getResponse(t, srv, http.MethodPost, "/configs", strings.NewReader(`{"name":"abc","labels":{"team":"sre"},"metadata":{}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = getResponse(t, srv, http.MethodGet, "/configs/abc", nil)
+		assertResponseBody(t, readBody(t, res), `{"id":1,"name":"abc","metadata":{},"labels":{"team":"sre"}}`)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBlockingWait = 5 * time.Minute
+	maxBlockingWait     = 10 * time.Minute
+)
+
+// parseBlockingQuery reads index and wait parameters, request blocks only when index is given
+func parseBlockingQuery(query url.Values) (index uint64, wait time.Duration, blocking bool, err error) {
+	v := query.Get("index")
+	if v == "" {
+		return 0, 0, false, nil
+	}
+
+	if index, err = strconv.ParseUint(v, 10, 64); err != nil {
+		return 0, 0, false, &ValidationError{Message: fmt.Sprintf("invalid index %q", v)}
+	}
+
+	wait = defaultBlockingWait
+	if v := query.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			return 0, 0, false, &ValidationError{Message: fmt.Sprintf("invalid wait %q", v)}
+		}
+	}
+	if wait > maxBlockingWait {
+		wait = maxBlockingWait
+	}
+
+	return index, wait, true, nil
+}
+
+// blockUntil re-checks ready on every matching event until it reports true, wait passes,
+// client goes away or server stops, whichever comes first
+func (srv *WebServer) blockUntil(r *http.Request, wait time.Duration, match func(*ConfigEvent) bool, ready func() (bool, error)) error {
+
+	// subscribe before the first check so no change slips in between
+	sub, _, _ := srv.events.Subscribe(srv.events.Seq(), match)
+	defer sub.Close()
+
+	extendWriteDeadline(r, wait+genericWebServerTimeout)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		done, err := ready()
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-timer.C:
+			return nil
+		case <-srv.stopping:
+			return nil
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case _, ok := <-sub.C:
+			if !ok {
+				// fell behind, let client look at the current state
+				return nil
+			}
+		}
+	}
+}
+
+// waitForConfig blocks until revision of config differs from index, lower revision means config was recreated,
+// env view is waited for by its own index
+func (srv *WebServer) waitForConfig(r *http.Request, name string) error {
+	index, wait, blocking, err := parseBlockingQuery(r.URL.Query())
+	if err != nil || !blocking {
+		return err
+	}
+
+	match := func(event *ConfigEvent) bool {
+		return event.Name == name
+	}
+
+	return srv.blockUntil(r, wait, match, func() (bool, error) {
+		if seq, ok, err := srv.envIndex(r, name); ok || err != nil {
+			return seq != index, err
+		}
+
+		cfg, err := srv.store.GetConfigByName(name)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return false, nil
+			}
+			return false, err
+		}
+		return cfg != nil && uint64(cfg.Revision) != index, nil
+	})
+}
+
+// envIndex returns index of env view of config named name, sequence number of the latest change of the config
+// or of any of its overlays, ok is false when no env view is asked for and revision of config is the index
+func (srv *WebServer) envIndex(r *http.Request, name string) (seq uint64, ok bool, err error) {
+	if r.URL.Query().Get("env") == "" {
+		return 0, false, nil
+	}
+	seq, err = srv.store.LastChangeSeqOf(name)
+	return seq, true, err
+}
+
+// waitForChanges blocks until global change sequence differs from index, lower sequence means server restarted
+func (srv *WebServer) waitForChanges(r *http.Request) error {
+	index, wait, blocking, err := parseBlockingQuery(r.URL.Query())
+	if err != nil || !blocking {
+		return err
+	}
+
+	return srv.blockUntil(r, wait, nil, func() (bool, error) {
+		return srv.events.Seq() != index, nil
+	})
+}
+
+// writeBlockingError responds to failed blocking query, nobody is listening once client went away
+func writeBlockingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeValidationError(w, verr)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// setChangesIndex exposes global change sequence for blocking list and search queries
+func (srv *WebServer) setChangesIndex(w http.ResponseWriter) {
+	w.Header().Set("X-Config-Index", strconv.FormatUint(srv.events.Seq(), 10))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockingQueries(t *testing.T) {
+
+	srv, _ := newTestServer(t, nil, func(db *Database) error {
+		_, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"v": "1"}})
+		return err
+	})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	// blockingGet issues request in background, result arrives on returned channel
+	blockingGet := func(path string) chan *http.Response {
+		results := make(chan *http.Response, 1)
+		go func() {
+			res, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Error("Unexpected error:", err)
+				close(results)
+				return
+			}
+			res.Body.Close()
+			results <- res
+		}()
+		return results
+	}
+
+	await := func(t *testing.T, results chan *http.Response) *http.Response {
+		t.Helper()
+		select {
+		case res := <-results:
+			return res
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for blocking query")
+			return nil
+		}
+	}
+
+	t.Run("config revision", func(t *testing.T) {
+		results := blockingGet("/configs/abc?index=1&wait=30s")
+
+		time.Sleep(50 * time.Millisecond)
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/configs/abc", strings.NewReader(`{"metadata":{"v":"2"}}`))
+		patch, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		patch.Body.Close()
+
+		res := await(t, results)
+		if res.Header.Get("X-Config-Index") != "2" {
+			t.Errorf("expected index %q but got %q", "2", res.Header.Get("X-Config-Index"))
+		}
+	})
+
+	t.Run("wait expires", func(t *testing.T) {
+		start := time.Now()
+		res := await(t, blockingGet("/configs/abc?index=2&wait=50ms"))
+
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if time.Since(start) < 50*time.Millisecond {
+			t.Error("expected request to block until wait expires")
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		index := srv.events.Seq()
+		results := blockingGet("/configs?index=" + uintString(index) + "&wait=30s")
+
+		time.Sleep(50 * time.Millisecond)
+		post, err := http.Post(ts.URL+"/configs", "application/json", strings.NewReader(`{"name":"def","metadata":{}}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		post.Body.Close()
+
+		res := await(t, results)
+		if res.Header.Get("X-Config-Index") != uintString(index+1) {
+			t.Errorf("expected index %q but got %q", uintString(index+1), res.Header.Get("X-Config-Index"))
+		}
+	})
+
+	t.Run("env overlay", func(t *testing.T) {
+		res := await(t, blockingGet("/configs/abc?env=prod"))
+		index := res.Header.Get("X-Config-Index")
+
+		results := blockingGet("/configs/abc?env=prod&index=" + index + "&wait=30s")
+
+		time.Sleep(50 * time.Millisecond)
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/configs/abc/overlays/prod", strings.NewReader(`{"metadata":{"v":"3"}}`))
+		put, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		put.Body.Close()
+
+		want := uintString(srv.events.Seq())
+		res = await(t, results)
+		if got := res.Header.Get("X-Config-Index"); got == index || got != want {
+			t.Errorf("expected index %q but got %q", want, got)
+		}
+	})
+
+	t.Run("invalid wait", func(t *testing.T) {
+		res := await(t, blockingGet("/search?index=1&wait=forever"))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		results := blockingGet("/configs/abc?index=2&wait=30s")
+
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+
+		res := await(t, results)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	})
+
+}
+
+func uintString(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
@@ -7,7 +7,10 @@ import (
 // tagRelease allows to set release version at compilation time
 var tagRelease string
 
-// app entrypoint
+// app entrypoint, runs server unless subcommand is given
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 	os.Exit(startServer())
 }
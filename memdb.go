@@ -16,6 +16,9 @@ func NewMemDatabaseStore(initFunction InitializerFunc) (*Database, func(), error
 		return nil, nil, err
 	}
 
+	// every new connection to :memory: opens a brand new database, keep single one
+	openFileDB.SetMaxOpenConns(1)
+
 	// close database on initialization error
 	defer func() {
 		if err != nil {
@@ -29,7 +32,11 @@ func NewMemDatabaseStore(initFunction InitializerFunc) (*Database, func(), error
 	}
 
 	// preapre db
-	db := &Database{DB: openFileDB}
+	if err = migrateDb(openFileDB); err != nil {
+		return nil, nil, err
+	}
+
+	db := &Database{DB: openFileDB, writer: openFileDB}
 
 	if initFunction != nil {
 		err = initFunction(db)
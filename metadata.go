@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type MetadataPolicy struct {
+	Strict       bool
+	MaxDepth     int
+	MaxKeyLength int
+	MaxSize      int
+}
+
+const (
+	metadataModeStrict = "strict"
+	metadataModeTyped  = "typed"
+)
+
+const (
+	defaultMetadataMaxDepth     = 16
+	defaultMetadataMaxKeyLength = 256
+	defaultMetadataMaxSize      = 64 * 1024
+
+	// metadataEnvelopeSize allows for config fields around metadata and formatting of request bodies
+	metadataEnvelopeSize = 4 * 1024
+)
+
+// newMetadataPolicy reads metadata restrictions from environment variables
+func newMetadataPolicy() (*MetadataPolicy, error) {
+	mode := getStringOrDefault("SERVE_METADATA_MODE", metadataModeTyped)
+	if mode != metadataModeStrict && mode != metadataModeTyped {
+		return nil, fmt.Errorf("environment variable %q must be either %q or %q", "SERVE_METADATA_MODE", metadataModeStrict, metadataModeTyped)
+	}
+
+	return &MetadataPolicy{
+		Strict:       mode == metadataModeStrict,
+		MaxDepth:     getIntOrDefault("SERVE_METADATA_MAX_DEPTH", defaultMetadataMaxDepth),
+		MaxKeyLength: getIntOrDefault("SERVE_METADATA_MAX_KEY_LENGTH", defaultMetadataMaxKeyLength),
+		MaxSize:      getIntOrDefault("SERVE_METADATA_MAX_SIZE", defaultMetadataMaxSize),
+	}, nil
+}
+
+// limitBody caps request body to what n metadata documents within the size limit take, so oversized
+// payloads fail while being read instead of after being decoded into memory
+func (p *MetadataPolicy) limitBody(w http.ResponseWriter, r *http.Request, n int) {
+	if p.MaxSize <= 0 {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(n)*int64(p.MaxSize+metadataEnvelopeSize))
+}
+
+// writeDecodeError reports request body which could not be decoded, bodies cut by limitBody get 413
+func writeDecodeError(w http.ResponseWriter, err error) {
+	// MaxBytesReader error has no exported type in Go 1.18
+	if strings.HasSuffix(err.Error(), "request body too large") {
+		http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// check verifies metadata against the policy, limits are ignored when set to zero or below
+func (p *MetadataPolicy) check(md *Metadata) (*ValidationError, error) {
+	buf, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.MaxSize > 0 && len(buf) > p.MaxSize {
+		return &ValidationError{
+			Message: "metadata is too large",
+			Fields:  []FieldError{{Field: "metadata", Message: fmt.Sprintf("document size %d exceeds limit of %d bytes", len(buf), p.MaxSize)}},
+		}, nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+
+	if errs := p.walk(v, "metadata", 0); len(errs) > 0 {
+		return &ValidationError{Message: "metadata violates server policy", Fields: errs}, nil
+	}
+
+	return nil, nil
+}
+
+// walk descends into metadata value collecting violations, depth counts nested objects
+func (p *MetadataPolicy) walk(v interface{}, path string, depth int) []FieldError {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		_, secret := t[secretKey]
+		_, encrypted := t[encryptedKey]
+		if secret || encrypted {
+			return checkSecret(t, path)
+		}
+		if p.MaxDepth > 0 && depth >= p.MaxDepth {
+			return []FieldError{{Field: path, Message: fmt.Sprintf("nesting depth exceeds limit of %d", p.MaxDepth)}}
+		}
+
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var errs []FieldError
+		for _, k := range keys {
+			if p.MaxKeyLength > 0 && len(k) > p.MaxKeyLength {
+				errs = append(errs, FieldError{Field: joinPath(path, truncateKey(k)), Message: fmt.Sprintf("key length %d exceeds limit of %d", len(k), p.MaxKeyLength)})
+				continue
+			}
+			errs = append(errs, p.walk(t[k], joinPath(path, k), depth+1)...)
+		}
+		return errs
+	case []interface{}:
+		if p.Strict {
+			return []FieldError{{Field: path, Message: "arrays are not allowed, value must be a string or an object"}}
+		}
+		if p.MaxDepth > 0 && depth >= p.MaxDepth {
+			return []FieldError{{Field: path, Message: fmt.Sprintf("nesting depth exceeds limit of %d", p.MaxDepth)}}
+		}
+		var errs []FieldError
+		for idx, item := range t {
+			errs = append(errs, p.walk(item, fmt.Sprintf("%s[%d]", path, idx), depth+1)...)
+		}
+		return errs
+	case string:
+		return nil
+	default:
+		if p.Strict {
+			return []FieldError{{Field: path, Message: fmt.Sprintf("expected string but got %s", jsonTypeOf(v))}}
+		}
+		return nil
+	}
+}
+
+// truncateKey shortens oversized key so it can be reported back
+func truncateKey(k string) string {
+	const keep = 32
+	if len(k) <= keep {
+		return k
+	}
+	return strings.ToValidUTF8(k[:keep], "") + "..."
+}
+
+// mergeMetadata applies overlay on top of base following JSON merge patch rules (RFC 7386):
+// objects are merged recursively, null removes the key, anything else replaces the value
+func mergeMetadata(base, overlay *Metadata) (*Metadata, error) {
+	b, err := genericMetadata(base)
+	if err != nil {
+		return nil, err
+	}
+	o, err := genericMetadata(overlay)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		o = map[string]interface{}{}
+	}
+
+	merged, ok := mergeValues(b, o).(map[string]interface{})
+	if !ok {
+		merged = map[string]interface{}{}
+	}
+
+	md := Metadata(merged)
+	return &md, nil
+}
+
+// mergeValues merges two decoded JSON values, see mergeMetadata
+func mergeValues(base, overlay interface{}) interface{} {
+	patch, ok := overlay.(map[string]interface{})
+	if !ok {
+		return overlay
+	}
+
+	target, ok := base.(map[string]interface{})
+	if !ok {
+		target = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(target))
+	for k, v := range target {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = mergeValues(merged[k], v)
+	}
+
+	return merged
+}
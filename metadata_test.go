@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetadataPolicy(t *testing.T) {
+
+	parse := func(t *testing.T, doc string) *Metadata {
+		t.Helper()
+		var md Metadata
+		if err := json.Unmarshal([]byte(doc), &md); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		return &md
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		p := &MetadataPolicy{Strict: true}
+
+		verr, err := p.check(parse(t, `{"a":{"b":"1","c":2,"d":null},"e":["x"],"f":"ok"}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if verr == nil {
+			t.Fatal("expected validation error, none returned")
+		}
+
+		want := []FieldError{
+			{Field: "metadata.a.c", Message: "expected string but got integer"},
+			{Field: "metadata.a.d", Message: "expected string but got null"},
+			{Field: "metadata.e", Message: "arrays are not allowed, value must be a string or an object"},
+		}
+
+		if !cmp.Equal(verr.Fields, want) {
+			t.Errorf("Field errors received\n%s", cmp.Diff(want, verr.Fields))
+		}
+	})
+
+	t.Run("typed", func(t *testing.T) {
+		p := &MetadataPolicy{}
+
+		verr, err := p.check(parse(t, `{"a":{"b":"1","c":2,"d":null},"e":["x"]}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if verr != nil {
+			t.Errorf("expected no validation error but got %v", verr)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		p := &MetadataPolicy{MaxDepth: 2, MaxKeyLength: 3}
+
+		verr, err := p.check(parse(t, `{"a":{"b":{"c":"1"}},"long":"1"}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if verr == nil {
+			t.Fatal("expected validation error, none returned")
+		}
+
+		want := []FieldError{
+			{Field: "metadata.a.b", Message: "nesting depth exceeds limit of 2"},
+			{Field: "metadata.long", Message: "key length 4 exceeds limit of 3"},
+		}
+
+		if !cmp.Equal(verr.Fields, want) {
+			t.Errorf("Field errors received\n%s", cmp.Diff(want, verr.Fields))
+		}
+
+		p = &MetadataPolicy{MaxSize: 10}
+
+		verr, err = p.check(parse(t, `{"key":"value"}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if verr == nil {
+			t.Fatal("expected validation error, none returned")
+		}
+	})
+
+	t.Run("strict post", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+		os.Setenv("SERVE_METADATA_MODE", "strict")
+		defer os.Unsetenv("SERVE_METADATA_MODE")
+
+		body := strings.NewReader(`{"name":"test","metadata":{"limits":{"cpu":{"enabled":true,"value":"300m"}}}}`)
+
+		req, res := prepareRequest(t, http.MethodPost, "/configs", body)
+
+		submitRequestInMem(t, nil, req, res)
+
+		assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+
+		got := res.Body.String()
+		want := `{"error":"metadata violates server policy","fields":[{"field":"metadata.limits.cpu.enabled","message":"expected string but got boolean"}]}`
+
+		assertResponseBody(t, got, want)
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		srv, _ := newTestServer(t, map[string]string{"SERVE_METADATA_MAX_SIZE": "1024"})
+
+		// within body allowance, rejected by policy once decoded
+		over := `{"name":"test","metadata":{"blob":"` + strings.Repeat("x", 2048) + `"}}`
+		res := getResponse(t, srv, http.MethodPost, "/configs", strings.NewReader(over))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+
+		// beyond it, cut while being read
+		huge := `{"name":"test","metadata":{"blob":"` + strings.Repeat("x", 8*1024) + `"}}`
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+			path := "/configs"
+			if method != http.MethodPost {
+				path += "/test"
+			}
+			res := getResponse(t, srv, method, path, strings.NewReader(huge))
+			assertResponseCode(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		os.Setenv("SERVE_METADATA_MODE", "loose")
+		defer os.Unsetenv("SERVE_METADATA_MODE")
+
+		if _, err := newMetadataPolicy(); err == nil {
+			t.Fatal("expected error, none thrown")
+		}
+	})
+
+}
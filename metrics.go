@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// writeCacheMetrics writes cache counters of config and list lookups in prometheus text format
+func writeCacheMetrics(w io.Writer, configs, lists CacheStats) {
+	metrics := []struct {
+		name, help, kind string
+		value            func(CacheStats) uint64
+	}{
+		{"fresh_cache_hits_total", "Lookups served from cache.", "counter", func(s CacheStats) uint64 { return s.Hits }},
+		{"fresh_cache_misses_total", "Lookups which went to the database.", "counter", func(s CacheStats) uint64 { return s.Misses }},
+		{"fresh_cache_evictions_total", "Entries evicted to keep cache within its size.", "counter", func(s CacheStats) uint64 { return s.Evictions }},
+		{"fresh_cache_entries", "Entries currently cached.", "gauge", func(s CacheStats) uint64 { return uint64(s.Entries) }},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		fmt.Fprintf(w, "%s{lookup=\"config\"} %d\n", m.name, m.value(configs))
+		fmt.Fprintf(w, "%s{lookup=\"list\"} %d\n", m.name, m.value(lists))
+	}
+}
+
+// metricsGetHandler handles GET /metrics
+func (srv *WebServer) metricsGetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP fresh_up Whether database is reachable.\n# TYPE fresh_up gauge\n")
+	up := 0
+	if srv.store.IsConnected() {
+		up = 1
+	}
+	fmt.Fprintf(w, "fresh_up %d\n", up)
+
+	// cache counters are only there when reads are cached
+	if srv.cache != nil {
+		configs, lists := srv.cache.Stats()
+		writeCacheMetrics(w, configs, lists)
+	}
+}
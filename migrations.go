@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schemaMigrations holds DDL statements applied on top of the base configs table,
+// position in the list (starting from 1) is stored in database as user_version
+var schemaMigrations = []string{
+	// base table, normally created by initializeDb already
+	`
+	CREATE TABLE IF NOT EXISTS configs (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(255) NOT NULL,
+		metadata TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_configs_created ON configs(created_at);
+	`,
+	// scheduled changes
+	`
+	CREATE TABLE pending_changes (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(255) NOT NULL,
+		metadata TEXT NOT NULL,
+		effective_at DATETIME NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		previous TEXT,
+		message TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		applied_at DATETIME
+	);
+	CREATE INDEX idx_pending_changes_due ON pending_changes(status, effective_at);
+	`,
+	// schema registry
+	`
+	CREATE TABLE schemas (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		document TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	ALTER TABLE configs ADD COLUMN schema VARCHAR(255) NOT NULL DEFAULT '';
+	`,
+	// revisions and history
+	`
+	ALTER TABLE configs ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+	CREATE INDEX idx_configs_name ON configs(name);
+	CREATE TABLE config_history (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		config_id INTEGER NOT NULL,
+		revision INTEGER NOT NULL,
+		metadata TEXT NOT NULL,
+		schema VARCHAR(255) NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_config_history_config ON config_history(config_id, revision);
+	`,
+	// clone provenance
+	`
+	ALTER TABLE configs ADD COLUMN cloned_from VARCHAR(255) NOT NULL DEFAULT '';
+	ALTER TABLE configs ADD COLUMN cloned_revision INTEGER NOT NULL DEFAULT 0;
+	`,
+	// inheritance
+	`
+	ALTER TABLE configs ADD COLUMN extends TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE config_history ADD COLUMN extends TEXT NOT NULL DEFAULT '[]';
+	`,
+	// environment overlays
+	`
+	CREATE TABLE config_overlays (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		config_id INTEGER NOT NULL,
+		env VARCHAR(63) NOT NULL,
+		metadata TEXT NOT NULL,
+		revision INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL,
+		UNIQUE (config_id, env)
+	);
+	CREATE TABLE overlay_history (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		config_id INTEGER NOT NULL,
+		env VARCHAR(63) NOT NULL,
+		revision INTEGER NOT NULL,
+		metadata TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_overlay_history_config ON overlay_history(config_id, env, revision);
+	`,
+	// webhooks and their delivery queue
+	`
+	CREATE TABLE webhooks (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		names TEXT NOT NULL DEFAULT '[]',
+		filters TEXT NOT NULL DEFAULT '{}',
+		events TEXT NOT NULL DEFAULT '[]',
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE webhook_deliveries (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_seq INTEGER NOT NULL,
+		event_type VARCHAR(16) NOT NULL,
+		config_name VARCHAR(255) NOT NULL,
+		payload TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		last_status_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		delivered_at DATETIME
+	);
+	CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, status);
+	`,
+	// change log written along with every config mutation
+	`
+	CREATE TABLE changes (
+		seq INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		type VARCHAR(16) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		revision INTEGER NOT NULL,
+		config TEXT,
+		created_at DATETIME NOT NULL
+	);
+	`,
+	// api keys, only their hashes are kept
+	`
+	CREATE TABLE api_keys (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL UNIQUE,
+		hash VARCHAR(64) NOT NULL,
+		admin BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+	`,
+	// role bindings managed through API
+	`
+	CREATE TABLE role_bindings (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		subject VARCHAR(255) NOT NULL,
+		role VARCHAR(16) NOT NULL,
+		pattern VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	`,
+	// audit trail, rows can be neither changed nor removed
+	`
+	CREATE TABLE audit_log (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		principal VARCHAR(255) NOT NULL,
+		source_ip VARCHAR(64) NOT NULL DEFAULT '',
+		method VARCHAR(16) NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		name VARCHAR(255) NOT NULL DEFAULT '',
+		request_id VARCHAR(64) NOT NULL DEFAULT '',
+		status INTEGER NOT NULL DEFAULT 0,
+		diff TEXT NOT NULL DEFAULT '[]'
+	);
+	CREATE INDEX idx_audit_log_name ON audit_log(name, created_at);
+	CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	`,
+	// names identify configs, duplicates already stored keep the oldest one under its name
+	// and get id of theirs appended to the name, e.g. abc-12
+	`
+	UPDATE configs SET name = name || '-' || id
+		WHERE id NOT IN (SELECT MIN(id) FROM configs GROUP BY name);
+	DROP INDEX idx_configs_name;
+	CREATE UNIQUE INDEX idx_configs_name ON configs(name);
+	`,
+	// positions consumers have reached in the change log, e.g. acknowledged websocket subscriptions
+	`
+	CREATE TABLE change_cursors (
+		consumer VARCHAR(255) NOT NULL PRIMARY KEY,
+		seq INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	`,
+	// overlay changes are recorded along with config ones, env is empty for the latter
+	`
+	ALTER TABLE changes ADD COLUMN env VARCHAR(255) NOT NULL DEFAULT '';
+	CREATE INDEX idx_changes_name ON changes(name, seq);
+	`,
+	// labels selected by watches, webhooks and role bindings
+	`
+	ALTER TABLE configs ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+	`,
+	// webhooks selecting configs by their labels
+	`
+	ALTER TABLE webhooks ADD COLUMN label_selector TEXT NOT NULL DEFAULT '';
+	`,
+	// role bindings scoped by config labels
+	`
+	ALTER TABLE role_bindings ADD COLUMN label_selector TEXT NOT NULL DEFAULT '';
+	`,
+}
+
+// migrateDb brings database structure up to date with schemaMigrations
+func migrateDb(db *sqlx.DB) error {
+
+	// fetch currently applied version
+	var version int
+	if err := db.Get(&version, `PRAGMA user_version`); err != nil {
+		return err
+	}
+
+	for idx := version; idx < len(schemaMigrations); idx++ {
+		if err := applyMigration(db, idx+1, schemaMigrations[idx]); err != nil {
+			return fmt.Errorf("unable to apply migration %d: %w", idx+1, err)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration executes single migration and bumps user_version in one transaction
+func applyMigration(db *sqlx.DB, version int, stmt string) (err error) {
+
+	// use transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// rollback or commit
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// execute DDL statement
+	if _, err = tx.Exec(stmt); err != nil {
+		return err
+	}
+
+	// pragma does not accept bind parameters
+	_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+	return err
+}
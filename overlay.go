@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+type Overlay struct {
+	Name     string    `db:"name" json:"name"`
+	Env      string    `db:"env" json:"env"`
+	Metadata *Metadata `db:"metadata" json:"metadata"`
+	Revision int       `db:"revision" json:"revision"`
+	Created  time.Time `db:"created_at" json:"-"`
+}
+
+// PutOverlay creates or replaces environment overlay of Config, previous revision goes to history
+func (db *Database) PutOverlay(ovr *Overlay) (bool, error) {
+	created := false
+
+	err := db.inTx(func(tx *sqlx.Tx) error {
+		cfg, err := getConfigTx(tx, ovr.Name)
+		if err != nil {
+			return err
+		}
+
+		current, err := getOverlayTx(tx, ovr.Name, ovr.Env)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			created = true
+			stmt := `INSERT INTO config_overlays (config_id, env, metadata, revision, created_at) VALUES (?, ?, ?, 1, datetime('now'))`
+			if _, err := tx.Exec(stmt, cfg.ID, ovr.Env, ovr.Metadata); err != nil {
+				return err
+			}
+			return recordOverlayTx(tx, eventOverlayCreated, ovr.Name, ovr.Env)
+		case err != nil:
+			return err
+		}
+
+		stmt := `INSERT INTO overlay_history (config_id, env, revision, metadata, created_at) VALUES (?, ?, ?, ?, datetime('now'))`
+		if _, err := tx.Exec(stmt, cfg.ID, current.Env, current.Revision, current.Metadata); err != nil {
+			return err
+		}
+
+		stmt = `UPDATE config_overlays SET metadata = ?, revision = revision + 1 WHERE config_id = ? AND env = ?`
+		if _, err := tx.Exec(stmt, ovr.Metadata, cfg.ID, ovr.Env); err != nil {
+			return err
+		}
+		return recordOverlayTx(tx, eventOverlayUpdated, ovr.Name, ovr.Env)
+	})
+
+	return created, err
+}
+
+// GetOverlay retrieves environment overlay of Config
+func (db *Database) GetOverlay(name, env string) (*Overlay, error) {
+	stmt := `SELECT c.name, o.env, o.metadata, o.revision, o.created_at FROM config_overlays o
+		JOIN configs c ON c.id = o.config_id WHERE c.name = ? AND o.env = ?`
+
+	ovr := &Overlay{}
+	if err := db.Get(ovr, stmt, name, env); err != nil {
+		return nil, err
+	}
+
+	return ovr, nil
+}
+
+// GetOverlays retrieves overlays of Config, or overlays of every Config for env if name is empty
+func (db *Database) GetOverlays(name, env string) (*[]Overlay, error) {
+	stmt := `SELECT c.name, o.env, o.metadata, o.revision, o.created_at FROM config_overlays o
+		JOIN configs c ON c.id = o.config_id WHERE (? = '' OR c.name = ?) AND (? = '' OR o.env = ?)
+		ORDER BY c.name ASC, o.env ASC`
+
+	ovrs := []Overlay{}
+	if err := db.Select(&ovrs, stmt, name, name, env, env); err != nil {
+		return nil, err
+	}
+
+	return &ovrs, nil
+}
+
+// DeleteOverlay removes environment overlay of Config along with its history
+func (db *Database) DeleteOverlay(name, env string) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		cfg, err := getConfigTx(tx, name)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`DELETE FROM config_overlays WHERE config_id = ? AND env = ?`, cfg.ID, env)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return sql.ErrNoRows
+		}
+
+		if _, err := tx.Exec(`DELETE FROM overlay_history WHERE config_id = ? AND env = ?`, cfg.ID, env); err != nil {
+			return err
+		}
+		return recordOverlayTx(tx, eventOverlayDeleted, name, env)
+	})
+}
+
+// GetOverlayHistory retrieves every revision of environment overlay, current one is the last
+func (db *Database) GetOverlayHistory(name, env string) (*[]ConfigRevision, error) {
+	stmt := `
+	SELECT h.revision, h.metadata, h.created_at FROM overlay_history h
+		JOIN configs c ON c.id = h.config_id WHERE c.name = ? AND h.env = ?
+	UNION ALL
+	SELECT o.revision, o.metadata, o.created_at FROM config_overlays o
+		JOIN configs c ON c.id = o.config_id WHERE c.name = ? AND o.env = ?
+	ORDER BY 1 ASC`
+
+	revs := []ConfigRevision{}
+	if err := db.Select(&revs, stmt, name, env, name, env); err != nil {
+		return nil, err
+	}
+	if len(revs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &revs, nil
+}
+
+// getOverlayTx retrieves environment overlay within transaction
+func getOverlayTx(tx *sqlx.Tx, name, env string) (*Overlay, error) {
+	stmt := `SELECT c.name, o.env, o.metadata, o.revision, o.created_at FROM config_overlays o
+		JOIN configs c ON c.id = o.config_id WHERE c.name = ? AND o.env = ?`
+
+	ovr := &Overlay{}
+	if err := tx.Get(ovr, stmt, name, env); err != nil {
+		return nil, err
+	}
+
+	return ovr, nil
+}
+
+// applyOverlay returns copy of cfg with overlay merged on top of its metadata
+func applyOverlay(cfg *Config, ovr *Overlay) (*Config, error) {
+	md, err := mergeMetadata(cfg.Metadata, ovr.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	merged := *cfg
+	merged.Metadata = md
+	return &merged, nil
+}
+
+// withEnvOverlay merges overlay for env into cfg if there is one
+func (srv *WebServer) withEnvOverlay(cfg *Config, env string) (*Config, error) {
+	ovr, err := srv.store.GetOverlay(cfg.Name, env)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	return applyOverlay(cfg, ovr)
+}
+
+// overlaysPutHandler handles PUT /configs/abc/overlays/prod
+func (srv *WebServer) overlaysPutHandler(w http.ResponseWriter, r *http.Request) {
+	var ovr Overlay
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&ovr); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	vars := mux.Vars(r)
+	ovr.Name, ovr.Env = vars["name"], vars["env"]
+
+	if verr := validateConfigName(ovr.Env); verr != nil {
+		verr.Message = "invalid environment name"
+		verr.Fields[0].Field = "env"
+		writeValidationError(w, verr)
+		return
+	}
+
+	cfg, err := srv.store.GetConfigByName(ovr.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// overlay itself obeys policy, merged result has to satisfy config schema
+	verr, err := srv.metadata.check(ovr.Metadata)
+	if err == nil && verr == nil {
+		var merged *Config
+		if merged, err = applyOverlay(cfg, &ovr); err == nil {
+			verr, err = srv.validateConfig(merged)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	created, err := srv.storeFor(r).PutOverlay(&ovr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "overlay has successfully been added")
+		return
+	}
+
+	fmt.Fprint(w, "overlay has successfully been updated")
+}
+
+// overlaysGetAllHandler handles GET /configs/abc/overlays
+func (srv *WebServer) overlaysGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	ovrs, err := srv.store.GetOverlays(name, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !srv.revealed(r, name) {
+		for idx := range *ovrs {
+			(*ovrs)[idx].Metadata = redactMetadata((*ovrs)[idx].Metadata)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(ovrs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// overlaysGetOneHandler handles GET /configs/abc/overlays/prod
+func (srv *WebServer) overlaysGetOneHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	ovr, err := srv.store.GetOverlay(vars["name"], vars["env"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "overlay was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !srv.revealed(r, ovr.Name) {
+		ovr.Metadata = redactMetadata(ovr.Metadata)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(ovr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// overlaysDeleteOneHandler handles DELETE /configs/abc/overlays/prod
+func (srv *WebServer) overlaysDeleteOneHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := srv.storeFor(r).DeleteOverlay(vars["name"], vars["env"]); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "overlay was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "overlay has successfully been erased")
+}
+
+// overlaysHistoryHandler handles GET /configs/abc/overlays/prod/history
+func (srv *WebServer) overlaysHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	revs, err := srv.store.GetOverlayHistory(vars["name"], vars["env"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "overlay was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !srv.revealed(r, vars["name"]) {
+		for idx := range *revs {
+			(*revs)[idx].Metadata = redactMetadata((*revs)[idx].Metadata)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(revs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
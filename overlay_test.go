@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOverlays(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			_, err := db.InsertConfig(&Config{Name: "dc", Metadata: &Metadata{"replicas": "1", "monitoring": map[string]string{"enabled": "false"}}})
+			return err
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPut,
+				path:   "/configs/dc/overlays/prod",
+				body:   strings.NewReader(`{"metadata":{"replicas":"3"}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusCreated)
+				},
+			},
+			{
+				method: http.MethodPut,
+				path:   "/configs/dc/overlays/prod",
+				body:   strings.NewReader(`{"metadata":{"replicas":"5","monitoring":{"enabled":"true"}}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), "overlay has successfully been updated")
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc?env=prod",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"dc","metadata":{"monitoring":{"enabled":"true"},"replicas":"5"}}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc?env=dev",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":1,"name":"dc","metadata":{"monitoring":{"enabled":"false"},"replicas":"1"}}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/search?env=prod&metadata.monitoring.enabled=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[{"id":1,"name":"dc","metadata":{"monitoring":{"enabled":"true"},"replicas":"5"}}]`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/search?metadata.monitoring.enabled=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[]`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc/overlays/prod/history",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					var revs []ConfigRevision
+					if err := json.Unmarshal(res.Body.Bytes(), &revs); err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					if len(revs) != 2 || revs[1].Revision != 2 {
+						t.Errorf("unexpected overlay history %s", res.Body.String())
+					}
+				},
+			},
+			{
+				method: http.MethodPut,
+				path:   "/configs/dc/overlays/Bad_Env",
+				body:   strings.NewReader(`{"metadata":{}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/dc/overlays/prod",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc/overlays",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `[]`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+}
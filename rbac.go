@@ -0,0 +1,363 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// RoleBinding grants role to principals matching subject glob on configs whose names match pattern glob,
+// subject is principal kind and name joined with colon, e.g. jwt:alice or api_key:ci
+type RoleBinding struct {
+	ID      int       `db:"id" json:"id,omitempty" yaml:"-"`
+	Subject string    `db:"subject" json:"subject" yaml:"subject"`
+	Role    string    `db:"role" json:"role" yaml:"role"`
+	Pattern string    `db:"pattern" json:"pattern" yaml:"pattern"`
+	Source  string    `db:"-" json:"source" yaml:"-"`
+	Created time.Time `db:"created_at" json:"created_at,omitempty" yaml:"-"`
+
+	// narrows binding to configs whose current labels match, e.g. team=sre
+	LabelSelector string `db:"label_selector" json:"label_selector,omitempty" yaml:"label_selector"`
+}
+
+// RBACPolicy holds bindings loaded from file and role granted to every authenticated principal
+type RBACPolicy struct {
+	DefaultRole string
+	bindings    []RoleBinding
+}
+
+const (
+	roleReader = "reader"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+
+	// revealer sees secret values of configs it can read, it is granted on its own or with admin
+	roleRevealer = "revealer"
+)
+
+const (
+	bindingSourceAPI  = "api"
+	bindingSourceFile = "file"
+)
+
+// roleRanks orders roles, every role includes the ones below it
+var roleRanks = map[string]int{roleReader: 1, roleEditor: 2, roleAdmin: 3}
+
+// anyConfig is checked against patterns for actions not bound to a single config,
+// only patterns matching every name match it
+const anyConfig = "*"
+
+// newRBACPolicy reads default role and bindings file from environment variables
+func newRBACPolicy() (*RBACPolicy, error) {
+	p := &RBACPolicy{DefaultRole: getStringOrDefault("SERVE_RBAC_DEFAULT_ROLE", "")}
+	if p.DefaultRole != "" {
+		if !knownRole(p.DefaultRole) {
+			return nil, fmt.Errorf("unknown default role %q", p.DefaultRole)
+		}
+	}
+
+	if file := getStringOrDefault("SERVE_RBAC_FILE", ""); file != "" {
+		if err := p.loadFile(file); err != nil {
+			return nil, fmt.Errorf("unable to load RBAC file: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// loadFile reads bindings from YAML or JSON file
+func (p *RBACPolicy) loadFile(file string) error {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Bindings []RoleBinding `yaml:"bindings"`
+	}
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+
+	for idx := range doc.Bindings {
+		if verr := validateRoleBinding(&doc.Bindings[idx]); verr != nil {
+			return fmt.Errorf("binding %d: %w", idx+1, verr)
+		}
+		doc.Bindings[idx].Source = bindingSourceFile
+	}
+	p.bindings = doc.Bindings
+	return nil
+}
+
+// validateRoleBinding checks role, both globs and label selector of binding,
+// bindings scoped by labels alone apply to any name
+func validateRoleBinding(b *RoleBinding) *ValidationError {
+	verr := &ValidationError{Message: "role binding is invalid"}
+
+	if b.Pattern == "" && b.LabelSelector != "" {
+		b.Pattern = anyConfig
+	}
+	if _, err := parseLabelSelector(b.LabelSelector); err != nil {
+		verr.Fields = append(verr.Fields, FieldError{Field: "label_selector", Message: err.Error()})
+	}
+
+	if !knownRole(b.Role) {
+		verr.Fields = append(verr.Fields, FieldError{Field: "role", Message: "must be one of reader, editor, admin, revealer"})
+	}
+	if _, err := path.Match(b.Subject, ""); b.Subject == "" || err != nil {
+		verr.Fields = append(verr.Fields, FieldError{Field: "subject", Message: "must be valid glob"})
+	}
+	if _, err := path.Match(b.Pattern, ""); b.Pattern == "" || err != nil {
+		verr.Fields = append(verr.Fields, FieldError{Field: "pattern", Message: "must be valid glob"})
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// knownRole reports whether role can be bound
+func knownRole(role string) bool {
+	_, ranked := roleRanks[role]
+	return ranked || role == roleRevealer
+}
+
+// includesRole reports whether held role grants wanted one, revealer stands outside of role ranks
+func includesRole(held, wanted string) bool {
+	if wanted == roleRevealer {
+		return held == roleRevealer || held == roleAdmin
+	}
+	if held == roleRevealer {
+		return false
+	}
+	return roleRanks[held] >= roleRanks[wanted]
+}
+
+// Subject returns identifier role bindings are matched against
+func (p *Principal) Subject() string {
+	return p.Kind + ":" + p.Name
+}
+
+// can reports whether principal holds at least role on config named name, labels of the config
+// are looked up only for bindings scoped by label selector, those never grant role on every config
+func (p *Principal) can(name, role string, labelsOf func(name string) StringMap) bool {
+	for _, grant := range p.grants {
+		if !includesRole(grant.Role, role) {
+			continue
+		}
+		if ok, _ := path.Match(grant.Pattern, name); !ok {
+			continue
+		}
+		if grant.LabelSelector == "" {
+			return true
+		}
+		if name == anyConfig {
+			continue
+		}
+		if selector, err := parseLabelSelector(grant.LabelSelector); err == nil && selector.matches(labelsOf(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsFor collects bindings of principal from file and database, admin api keys and tokens
+// with admin scope are admins of everything
+func (srv *WebServer) grantsFor(principal *Principal) ([]RoleBinding, error) {
+	stored, err := srv.store.GetRoleBindings()
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []RoleBinding
+	if principal.Admin {
+		grants = append(grants, RoleBinding{Role: roleAdmin, Pattern: anyConfig})
+	}
+	if srv.rbac.DefaultRole != "" {
+		grants = append(grants, RoleBinding{Role: srv.rbac.DefaultRole, Pattern: anyConfig})
+	}
+
+	subject := principal.Subject()
+	for _, b := range append(srv.rbac.bindings, *stored...) {
+		if ok, _ := path.Match(b.Subject, subject); ok {
+			grants = append(grants, b)
+		}
+	}
+	return grants, nil
+}
+
+// allowed reports whether caller holds role on config named name, everything is allowed without authentication
+func (srv *WebServer) allowed(r *http.Request, name, role string) bool {
+	if !srv.auth.Enabled {
+		return true
+	}
+	principal := principalFrom(r)
+	return principal != nil && principal.can(name, role, srv.configLabels)
+}
+
+// configLabels returns labels config named name currently has, none if it can not be read
+func (srv *WebServer) configLabels(name string) StringMap {
+	cfg, err := srv.store.GetConfigByName(name)
+	if err != nil || cfg == nil {
+		return nil
+	}
+	return cfg.Labels
+}
+
+// authorize responds with 403 unless caller holds role on config named name
+func (srv *WebServer) authorize(w http.ResponseWriter, r *http.Request, name, role string) bool {
+	if srv.allowed(r, name, role) {
+		return true
+	}
+	if name == anyConfig {
+		http.Error(w, fmt.Sprintf("%s role on every configuration item is required", role), http.StatusForbidden)
+	} else {
+		http.Error(w, fmt.Sprintf("%s role on configuration item %q is required", role, name), http.StatusForbidden)
+	}
+	return false
+}
+
+// requireRole allows caller holding role on config of the route, or on every config for routes without one
+func (srv *WebServer) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := mux.Vars(r)["name"]
+		if !ok {
+			name = anyConfig
+		}
+		if srv.authorize(w, r, name, role) {
+			next(w, r)
+		}
+	}
+}
+
+// readable returns filter of config names caller is allowed to read
+func (srv *WebServer) readable(r *http.Request) func(name string) bool {
+	return func(name string) bool {
+		return srv.allowed(r, name, roleReader)
+	}
+}
+
+// filterConfigs keeps configs caller is allowed to read
+func (srv *WebServer) filterConfigs(r *http.Request, cfgs *[]Config) *[]Config {
+	if !srv.auth.Enabled {
+		return cfgs
+	}
+
+	visible := make([]Config, 0, len(*cfgs))
+	for _, cfg := range *cfgs {
+		if srv.allowed(r, cfg.Name, roleReader) {
+			visible = append(visible, cfg)
+		}
+	}
+	return &visible
+}
+
+// InsertRoleBinding stores role binding
+func (db *Database) InsertRoleBinding(b *RoleBinding) (int, error) {
+	stmt := `INSERT INTO role_bindings (subject, role, pattern, label_selector, created_at) VALUES (?, ?, ?, ?, datetime('now'))`
+
+	result, err := db.execTx(stmt, b.Subject, b.Role, b.Pattern, b.LabelSelector)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetRoleBindings retrieves role bindings managed through API
+func (db *Database) GetRoleBindings() (*[]RoleBinding, error) {
+	bindings := []RoleBinding{}
+	if err := db.Select(&bindings, `SELECT id, subject, role, pattern, label_selector, created_at FROM role_bindings ORDER BY id ASC`); err != nil {
+		return nil, err
+	}
+	for idx := range bindings {
+		bindings[idx].Source = bindingSourceAPI
+	}
+	return &bindings, nil
+}
+
+// DeleteRoleBinding removes role binding, sql.ErrNoRows is returned if there is nothing to remove
+func (db *Database) DeleteRoleBinding(id int) error {
+	_, err := db.execTx(`DELETE FROM role_bindings WHERE id = ?`, id)
+	return err
+}
+
+// roleBindingsPostHandler handles POST /admin/rolebindings
+func (srv *WebServer) roleBindingsPostHandler(w http.ResponseWriter, r *http.Request) {
+	var b RoleBinding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if verr := validateRoleBinding(&b); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	id, err := srv.storeFor(r).InsertRoleBinding(&b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b.ID = id
+	b.Source = bindingSourceAPI
+	b.Created = time.Now().UTC()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// roleBindingsGetAllHandler handles GET /admin/rolebindings, bindings from file come first
+func (srv *WebServer) roleBindingsGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	stored, err := srv.store.GetRoleBindings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bindings := append(append([]RoleBinding{}, srv.rbac.bindings...), *stored...)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(bindings); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// roleBindingsDeleteOneHandler handles DELETE /admin/rolebindings/123
+func (srv *WebServer) roleBindingsDeleteOneHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if err := srv.storeFor(r).DeleteRoleBinding(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "role binding was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "role binding has successfully been removed")
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configNames decodes list of configs into their names
+func configNames(t *testing.T, res *http.Response) string {
+	t.Helper()
+
+	var cfgs []Config
+	if err := json.NewDecoder(res.Body).Decode(&cfgs); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	names := []string{}
+	for _, cfg := range cfgs {
+		names = append(names, cfg.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRBAC(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rbac.yaml")
+	policy := `
+bindings:
+  - subject: "api_key:sre"
+    role: editor
+    pattern: "datacenter-*"
+  - subject: "api_key:app-*"
+    role: reader
+    pattern: "*"
+`
+	if err := os.WriteFile(file, []byte(policy), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	srv, _ := newTestServer(t, map[string]string{"SERVE_AUTH_ENABLED": "true", "SERVE_RBAC_FILE": file}, func(db *Database) error {
+		for _, name := range []string{"datacenter-1", "app-one"} {
+			if _, err := db.InsertConfig(&Config{Name: name, Metadata: &Metadata{"a": "1"}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	keys := map[string]string{}
+	for _, name := range []string{"sre", "app-team", "nobody", "ops"} {
+		_, key, err := srv.createAPIKey(srv.store, name, name == "ops")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		keys[name] = key
+	}
+
+	request := func(who, method, path, body string) *http.Response {
+		t.Helper()
+		return getResponse(t, srv, method, path, strings.NewReader(body), apiKeyHeader, keys[who])
+	}
+
+	t.Run("sre edits datacenters", func(t *testing.T) {
+		res := request("sre", "PATCH", "/configs/datacenter-1", `{"metadata":{"a":"2"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("sre", "POST", "/configs", `{"name":"datacenter-2","metadata":{"a":"1"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("sre", "PATCH", "/configs/app-one", `{"metadata":{"a":"2"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("sre", "POST", "/configs/datacenter-2/rename", `{"name":"app-two"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("sre", "POST", "/configs:batch", `{"operations":[{"op":"merge","name":"datacenter-1","metadata":{"b":"1"}},{"op":"delete","name":"app-one"}]}`)
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("sre", "GET", "/configs", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if got := configNames(t, res); got != "datacenter-1,datacenter-2" {
+			t.Errorf("expected datacenters only but got %q", got)
+		}
+	})
+
+	t.Run("app teams only read", func(t *testing.T) {
+		res := request("app-team", "GET", "/configs/datacenter-1", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("app-team", "PATCH", "/configs/datacenter-1", `{"metadata":{"a":"3"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("app-team", "DELETE", "/configs/app-one", "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("app-team", "GET", "/search?metadata.a=1", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if got := configNames(t, res); got != "app-one,datacenter-2" {
+			t.Errorf("expected every match but got %q", got)
+		}
+	})
+
+	t.Run("scheduled changes", func(t *testing.T) {
+		res := request("sre", "POST", "/configs/datacenter-1/schedules", `{"metadata":{"a":"9"},"effective_at":"2099-01-01T00:00:00Z"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		var chg ScheduledChange
+		if err := json.NewDecoder(res.Body).Decode(&chg); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		path := "/schedules/" + uintString(uint64(chg.ID))
+
+		res = request("app-team", "DELETE", path, "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("sre", "DELETE", "/schedules/999", "")
+		assertResponseCode(t, res.StatusCode, http.StatusNotFound)
+
+		res = request("sre", "DELETE", path, "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	})
+
+	t.Run("unbound principal sees nothing", func(t *testing.T) {
+		res := request("nobody", "GET", "/configs/app-one", "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("nobody", "GET", "/search?metadata.a=1", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if got := configNames(t, res); got != "" {
+			t.Errorf("expected no results but got %q", got)
+		}
+
+		res = request("nobody", "GET", "/changes", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		var changes []ConfigEvent
+		json.NewDecoder(res.Body).Decode(&changes)
+		if len(changes) != 0 {
+			t.Errorf("expected no changes but got %d", len(changes))
+		}
+
+		res = request("nobody", "GET", "/admin/rolebindings", "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("bindings managed through api", func(t *testing.T) {
+		res := request("ops", "POST", "/admin/rolebindings", `{"subject":"api_key:nobody","role":"editor","pattern":"app-*"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		var created RoleBinding
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		res = request("nobody", "PATCH", "/configs/app-one", `{"metadata":{"a":"4"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("ops", "GET", "/admin/rolebindings", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		var bindings []RoleBinding
+		json.NewDecoder(res.Body).Decode(&bindings)
+		if len(bindings) != 3 || bindings[0].Source != bindingSourceFile || bindings[2].Source != bindingSourceAPI {
+			t.Errorf("unexpected bindings %+v", bindings)
+		}
+
+		res = request("ops", "DELETE", "/admin/rolebindings/"+uintString(uint64(created.ID)), "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("nobody", "PATCH", "/configs/app-one", `{"metadata":{"a":"5"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("ops", "POST", "/admin/rolebindings", `{"subject":"api_key:nobody","role":"owner","pattern":"app-*"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+
+		res = request("ops", "POST", "/admin/rolebindings", `{"subject":"api_key:nobody","role":"reader","label_selector":"team=a b"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("bindings scoped by labels", func(t *testing.T) {
+		res := request("ops", "POST", "/configs", `{"name":"app-two","labels":{"team":"app"},"metadata":{"a":"1"}}`)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("ops", "POST", "/admin/rolebindings", `{"subject":"api_key:nobody","role":"reader","label_selector":"team=app"}`)
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		res = request("nobody", "GET", "/configs/app-two", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = request("nobody", "GET", "/configs/app-one", "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+		res = request("nobody", "GET", "/search?metadata.a=1", "")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if got := configNames(t, res); got != "app-two" {
+			t.Errorf("expected labelled config only but got %q", got)
+		}
+
+		// label scoped bindings never cover every config
+		res = request("nobody", "GET", "/admin/rolebindings", "")
+		assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+	})
+}
+
+func TestRBACReferences(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rbac.yaml")
+	policy := `
+bindings:
+  - subject: "api_key:sre"
+    role: reader
+    pattern: "datacenter-*"
+`
+	if err := os.WriteFile(file, []byte(policy), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	srv, _ := newTestServer(t, map[string]string{"SERVE_AUTH_ENABLED": "true", "SERVE_RBAC_FILE": file}, func(db *Database) error {
+		for _, cfg := range []Config{
+			{Name: "app-base", Metadata: &Metadata{"token": "t0p"}},
+			{Name: "datacenter-1", Metadata: &Metadata{"a": "1"}, Extends: ConfigNames{"app-base"}},
+			{Name: "datacenter-2", Metadata: &Metadata{"token": "${ref:app-base#token}"}},
+		} {
+			cfg := cfg
+			if _, err := db.InsertConfig(&cfg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	keys := map[string]string{}
+	for _, name := range []string{"sre", "ops"} {
+		_, key, err := srv.createAPIKey(srv.store, name, name == "ops")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		keys[name] = key
+	}
+
+	request := func(who, path string) *http.Response {
+		t.Helper()
+		return getResponse(t, srv, "GET", path, nil, apiKeyHeader, keys[who])
+	}
+
+	t.Run("resolved", func(t *testing.T) {
+		res := request("sre", "/configs/datacenter-1?resolved=true")
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		if body := readBody(t, res); strings.Contains(body, "t0p") || !strings.Contains(body, "not readable") {
+			t.Errorf("expected unreadable parent to be refused but got %s", body)
+		}
+
+		res = request("sre", "/search?resolved=true&metadata.token=t0p")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if got := configNames(t, res); got != "" {
+			t.Errorf("expected no match through unreadable parent but got %q", got)
+		}
+
+		res = request("ops", "/configs/datacenter-1?resolved=true")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if body := readBody(t, res); !strings.Contains(body, "t0p") {
+			t.Errorf("expected parent to be merged for admin but got %s", body)
+		}
+	})
+
+	t.Run("render", func(t *testing.T) {
+		res := request("sre", "/configs/datacenter-2?render=true")
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		if body := readBody(t, res); strings.Contains(body, "t0p") || !strings.Contains(body, "not readable") {
+			t.Errorf("expected unreadable reference to be refused but got %s", body)
+		}
+
+		res = request("sre", "/configs?render=true")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		var items []renderedConfig
+		if err := json.NewDecoder(res.Body).Decode(&items); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		for _, item := range items {
+			if item.Name == "datacenter-2" && item.RenderError == nil {
+				t.Errorf("expected unreadable reference to be reported but got %+v", item)
+			}
+		}
+
+		res = request("ops", "/configs/datacenter-2?render=true")
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if body := readBody(t, res); !strings.Contains(body, "t0p") {
+			t.Errorf("expected reference to be rendered for admin but got %s", body)
+		}
+	})
+}
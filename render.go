@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches ${ref:name#path.to.key} and ${env:NAME} placeholders
+var placeholderPattern = regexp.MustCompile(`\$\{(ref|env):([^}]*)\}`)
+
+const maxRenderDepth = 16
+
+// configRenderer expands placeholders found in metadata string values
+type configRenderer struct {
+	lookup     func(name string) (*Config, error)
+	readable   func(name string) bool
+	revealable func(name string) bool
+	envAllow   map[string]bool
+	rendered   map[string]interface{}
+}
+
+// newRenderer creates renderer which fetches referenced configs from the store, refusing those not readable,
+// secrets referenced from configs which are not revealable are copied redacted
+func (srv *WebServer) newRenderer(readable, revealable func(name string) bool) *configRenderer {
+	return &configRenderer{
+		lookup:     srv.store.GetConfigByName,
+		readable:   readable,
+		revealable: revealable,
+		envAllow:   srv.renderEnv,
+		rendered:   map[string]interface{}{},
+	}
+}
+
+// parseRenderEnv reads list of environment variables which may be exposed through ${env:...}
+func parseRenderEnv() map[string]bool {
+	allow := map[string]bool{}
+	for _, name := range strings.Split(getStringOrDefault("SERVE_RENDER_ENV", ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allow[name] = true
+		}
+	}
+	return allow
+}
+
+// renderedConfig is entry of rendered config list, config which cannot be rendered is listed as stored
+// along with the reason
+type renderedConfig struct {
+	Config
+	RenderError *ValidationError `json:"render_error,omitempty"`
+}
+
+// renderConfigs renders every config of the list, returned error is never caused by configs themselves
+func (srv *WebServer) renderConfigs(r *http.Request, cfgs *[]Config) ([]renderedConfig, error) {
+	renderer := srv.newRenderer(srv.readable(r), srv.revealable(r))
+
+	items := make([]renderedConfig, 0, len(*cfgs))
+	for idx := range *cfgs {
+		cfg := &(*cfgs)[idx]
+
+		rendered, err := renderer.renderConfig(cfg)
+		if err != nil {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				return nil, err
+			}
+			items = append(items, renderedConfig{Config: *srv.redactConfig(r, cfg), RenderError: verr})
+			continue
+		}
+		items = append(items, renderedConfig{Config: *srv.redactConfig(r, rendered)})
+	}
+
+	return items, nil
+}
+
+// renderConfig returns copy of cfg with placeholders expanded, problems are reported as *ValidationError
+func (r *configRenderer) renderConfig(cfg *Config) (*Config, error) {
+	v, err := r.renderMetadata(cfg.Metadata, []string{cfg.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	md, ok := v.(map[string]interface{})
+	if !ok {
+		md = map[string]interface{}{}
+	}
+	metadata := Metadata(md)
+
+	rendered := *cfg
+	rendered.Metadata = &metadata
+	return &rendered, nil
+}
+
+// renderMetadata expands metadata, chain holds names of configs being rendered
+func (r *configRenderer) renderMetadata(md *Metadata, chain []string) (interface{}, error) {
+	v, err := genericMetadata(md)
+	if err != nil {
+		return nil, err
+	}
+	return r.renderValue(v, "metadata", chain)
+}
+
+// renderValue walks decoded JSON value expanding string leaves
+func (r *configRenderer) renderValue(v interface{}, path string, chain []string) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			rendered, err := r.renderValue(item, joinPath(path, k), chain)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for idx, item := range t {
+			rendered, err := r.renderValue(item, fmt.Sprintf("%s[%d]", path, idx), chain)
+			if err != nil {
+				return nil, err
+			}
+			out[idx] = rendered
+		}
+		return out, nil
+	case string:
+		return r.renderString(t, path, chain)
+	default:
+		return v, nil
+	}
+}
+
+// renderString expands placeholders in s, value consisting of single placeholder takes type of the target,
+// string with a secret embedded into it becomes secret as a whole
+func (r *configRenderer) renderString(s, path string, chain []string) (interface{}, error) {
+	matches := placeholderPattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+
+	var out strings.Builder
+	last := 0
+	secret := false
+	for _, m := range matches {
+		kind, arg := s[m[2]:m[3]], s[m[4]:m[5]]
+
+		value, err := r.expand(kind, arg, path, chain)
+		if err != nil {
+			return nil, err
+		}
+
+		// whole value is a placeholder, keep referenced value as is
+		if m[0] == 0 && m[1] == len(s) {
+			return value, nil
+		}
+
+		if _, inner, ok := secretLeaf(value); ok {
+			value, secret = inner, true
+		}
+
+		switch t := value.(type) {
+		case string:
+			out.WriteString(s[last:m[0]])
+			out.WriteString(t)
+		case map[string]interface{}, []interface{}:
+			return nil, renderError(path, fmt.Sprintf("%q refers to an object and cannot be embedded into a string", s[m[0]:m[1]]))
+		default:
+			buf, _ := json.Marshal(t)
+			out.WriteString(s[last:m[0]])
+			out.Write(buf)
+		}
+		last = m[1]
+	}
+	out.WriteString(s[last:])
+
+	if secret {
+		return map[string]interface{}{secretKey: out.String()}, nil
+	}
+	return out.String(), nil
+}
+
+// expand resolves single placeholder
+func (r *configRenderer) expand(kind, arg, path string, chain []string) (interface{}, error) {
+	if kind == "env" {
+		if !r.envAllow[arg] {
+			return nil, renderError(path, fmt.Sprintf("environment variable %q is not allowed to be rendered", arg))
+		}
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			return nil, renderError(path, fmt.Sprintf("environment variable %q is not set", arg))
+		}
+		return v, nil
+	}
+
+	name, keyPath := parseReference(arg)
+	if name == "" {
+		return nil, renderError(path, fmt.Sprintf("invalid reference %q", arg))
+	}
+
+	for _, seen := range chain {
+		if seen == name {
+			return nil, renderError(path, "reference cycle detected: "+strings.Join(append(chain[:len(chain):len(chain)], name), " -> "))
+		}
+	}
+	if len(chain) >= maxRenderDepth {
+		return nil, renderError(path, fmt.Sprintf("references are nested deeper than %d levels", maxRenderDepth))
+	}
+
+	// checked before the lookup so that existence of the target is not revealed either
+	if !r.readable(name) {
+		return nil, renderError(path, fmt.Sprintf("referenced configuration item %q is not readable", name))
+	}
+
+	target, ok := r.rendered[name]
+	if !ok {
+		cfg, err := r.lookup(name)
+		if err == nil && cfg == nil {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, renderError(path, fmt.Sprintf("referenced configuration item %q was not found", name))
+			}
+			return nil, err
+		}
+
+		target, err = r.renderMetadata(cfg.Metadata, append(chain[:len(chain):len(chain)], name))
+		if err != nil {
+			return nil, err
+		}
+		r.rendered[name] = target
+	}
+
+	// secrets are referenced as a whole so that they stay wrapped and redaction hides them
+	value := target
+	for idx, key := range keyPath {
+		if _, _, ok := secretLeaf(value); ok {
+			return nil, renderError(path, fmt.Sprintf("key %q of %q is a secret and cannot be read into", strings.Join(keyPath[:idx], "."), name))
+		}
+		obj, ok := value.(map[string]interface{})
+		if ok {
+			value, ok = obj[key]
+		}
+		if !ok {
+			return nil, renderError(path, fmt.Sprintf("key %q was not found in %q", strings.Join(keyPath, "."), name))
+		}
+	}
+
+	// rendered config may be revealed while referenced one may not
+	if r.revealable != nil && !r.revealable(name) {
+		redacted, _, err := rewriteSecrets(value, redactSecret)
+		if err != nil {
+			return nil, err
+		}
+		value = redacted
+	}
+
+	return value, nil
+}
+
+// parseReference splits name#path.to.key reference
+func parseReference(ref string) (string, []string) {
+	name, keys, found := strings.Cut(ref, "#")
+	if !found || keys == "" {
+		return name, nil
+	}
+	return name, strings.Split(keys, ".")
+}
+
+// renderError reports failed placeholder expansion
+func renderError(path, msg string) *ValidationError {
+	return &ValidationError{
+		Message: "unable to render metadata",
+		Fields:  []FieldError{{Field: path, Message: msg}},
+	}
+}
+
+// presentConfig applies optional resolved=true, env=prod and render=true views to cfg, in that order,
+// only configs caller is allowed to read may be merged or referenced
+func (srv *WebServer) presentConfig(r *http.Request, cfg *Config) (*Config, error) {
+	query := r.URL.Query()
+
+	var err error
+	if isTrue(query.Get("resolved")) {
+		if cfg, err = srv.newStoreResolver(srv.store, srv.readable(r)).resolvedConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if env := query.Get("env"); env != "" {
+		if cfg, err = srv.withEnvOverlay(cfg, env); err != nil {
+			return nil, err
+		}
+	}
+	if isTrue(query.Get("render")) {
+		if cfg, err = srv.newRenderer(srv.readable(r), srv.revealable(r)).renderConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderConfigs(t *testing.T) {
+
+	initDB := func(t *testing.T) InitializerFunc {
+		return func(db *Database) error {
+			createTable(t, db)
+			cfgs := []*Config{
+				{Name: "shared-limits", Metadata: &Metadata{"cpu": map[string]string{"value": "300m"}, "replicas": 3}},
+				{Name: "dc", Metadata: &Metadata{"cpu": "${ref:shared-limits#cpu.value}", "label": "${env:REGION}-x${ref:shared-limits#replicas}"}},
+				{Name: "loop-a", Metadata: &Metadata{"v": "${ref:loop-b#v}"}},
+				{Name: "loop-b", Metadata: &Metadata{"v": "${ref:loop-a#v}"}},
+			}
+			for _, cfg := range cfgs {
+				if _, err := db.InsertConfig(cfg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	t.Run("render", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+		os.Setenv("SERVE_RENDER_ENV", "REGION")
+		defer os.Unsetenv("SERVE_RENDER_ENV")
+		os.Setenv("REGION", "eu")
+		defer os.Unsetenv("REGION")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc?render=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `{"id":2,"name":"dc","metadata":{"cpu":"300m","label":"eu-x3"}}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/dc",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseBody(t, res.Body.String(), `{"id":2,"name":"dc","metadata":{"cpu":"${ref:shared-limits#cpu.value}","label":"${env:REGION}-x${ref:shared-limits#replicas}"}}`)
+				},
+			},
+			{
+				method: http.MethodGet,
+				path:   "/configs/loop-a?render=true",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+					assertResponseBody(t, res.Body.String(), `{"error":"unable to render metadata","fields":[{"field":"metadata.v","message":"reference cycle detected: loop-a -> loop-b -> loop-a"}]}`)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+	t.Run("env not allowed", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		req, res := prepareRequest(t, http.MethodGet, "/configs/dc?render=true", nil)
+
+		submitRequestInMem(t, initDB(t), req, res)
+
+		assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("dependents", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodGet,
+				path:   "/configs/shared-limits/dependents",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), `["dc"]`)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/shared-limits",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/shared-limits/rename",
+				body:   strings.NewReader(`{"name":"limits"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusConflict)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/dc",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/configs/shared-limits",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB(t), &testPairs)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		srv, _ := newTestServer(t, map[string]string{"SERVE_RENDER_ENV": "REGION", "REGION": "eu"}, initDB(t))
+
+		res := getResponse(t, srv, http.MethodGet, "/configs?render=true", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		var items []renderedConfig
+		if err := json.NewDecoder(res.Body).Decode(&items); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		failed := map[string]bool{}
+		for _, item := range items {
+			failed[item.Name] = item.RenderError != nil
+		}
+		want := map[string]bool{"shared-limits": false, "dc": false, "loop-a": true, "loop-b": true}
+		if !cmp.Equal(failed, want) {
+			t.Errorf("Render failures received\n%s", cmp.Diff(want, failed))
+		}
+	})
+
+	t.Run("overlay dependents", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil, initDB(t))
+
+		res := getResponse(t, srv, http.MethodPut, "/configs/loop-a/overlays/prod", strings.NewReader(`{"metadata":{"cpu":"${ref:shared-limits#cpu.value}"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+		res = getResponse(t, srv, http.MethodDelete, "/configs/dc", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = getResponse(t, srv, http.MethodGet, "/configs/shared-limits/dependents", nil)
+		assertResponseBody(t, readBody(t, res), `["loop-a"]`)
+		res = getResponse(t, srv, http.MethodDelete, "/configs/shared-limits", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusConflict)
+	})
+
+}
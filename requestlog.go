@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// incoming request ids are kept only when they are safe to log and store
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestInfoKey struct{}
+
+// requestInfo is shared by middlewares of single request, inner ones fill it for the outer ones
+type requestInfo struct {
+	id        string
+	principal *Principal
+	rec       *responseRecorder
+}
+
+// responseRecorder remembers status and size of response, streaming handlers keep access to
+// flushing and hijacking of the underlying writer
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records status
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records size, status defaults to 200 like it does for the underlying writer
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher for server-sent events
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for websocket upgrades
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// code returns status of response, handlers which wrote nothing have responded with 200
+func (rec *responseRecorder) code() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// newRequestID generates random request id
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// requestInfoFrom returns info of request, nil for requests not passed through withRequestLog
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestIDFrom returns id of request
+func requestIDFrom(r *http.Request) string {
+	if info := requestInfoFrom(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// sourceIP returns address of the remote peer
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withRequestLog assigns every request an id and logs it once it is served, probes are logged on debug level
+func (srv *WebServer) withRequestLog(next http.Handler) http.Handler {
+	logRequests := getBoolOrDefault("SERVE_LOG_REQUESTS", true)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		info := &requestInfo{id: id, rec: rec}
+		started := time.Now()
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		if !logRequests {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("request_id", id),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.code()),
+			zap.Int("bytes", rec.bytes),
+			zap.Duration("duration", time.Since(started)),
+			zap.String("source_ip", sourceIP(r)),
+		}
+		if info.principal != nil {
+			fields = append(fields, zap.String("principal", info.principal.Subject()))
+		}
+
+		if r.URL.Path == "/healthz" {
+			srv.log.Debug("Request served", fields...)
+			return
+		}
+		srv.log.Info("Request served", fields...)
+	})
+}
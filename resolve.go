@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const defaultExtendsMaxDepth = 8
+
+// configResolver computes effective metadata of configs by merging their parents
+type configResolver struct {
+	lookup   func(name string) (*Config, error)
+	readable func(name string) bool
+	maxDepth int
+	resolved map[string]*Metadata
+}
+
+// newStoreResolver creates resolver which fetches parents from the store or transaction,
+// parents which are not readable are refused, nil readable allows every config
+func (srv *WebServer) newStoreResolver(reader configReader, readable func(name string) bool) *configResolver {
+	return &configResolver{
+		lookup:   reader.GetConfigByName,
+		readable: readable,
+		maxDepth: srv.extendsMaxDepth,
+		resolved: map[string]*Metadata{},
+	}
+}
+
+// newListResolver creates resolver which looks parents up in already loaded configs
+func (srv *WebServer) newListResolver(cfgs []Config, readable func(name string) bool) *configResolver {
+	byName := make(map[string]*Config, len(cfgs))
+	for idx := range cfgs {
+		byName[cfgs[idx].Name] = &cfgs[idx]
+	}
+
+	return &configResolver{
+		lookup: func(name string) (*Config, error) {
+			cfg, ok := byName[name]
+			if !ok {
+				return nil, sql.ErrNoRows
+			}
+			return cfg, nil
+		},
+		readable: readable,
+		maxDepth: srv.extendsMaxDepth,
+		resolved: map[string]*Metadata{},
+	}
+}
+
+// resolve returns effective metadata of cfg, problems with parents are reported as *ValidationError
+func (r *configResolver) resolve(cfg *Config) (*Metadata, error) {
+	return r.resolveChain(cfg, []string{cfg.Name})
+}
+
+// resolveChain merges parents in declared order and own overrides on top, chain holds names being resolved
+func (r *configResolver) resolveChain(cfg *Config, chain []string) (*Metadata, error) {
+	if len(cfg.Extends) == 0 {
+		return cfg.Metadata, nil
+	}
+
+	if r.maxDepth > 0 && len(chain) > r.maxDepth {
+		return nil, &ValidationError{
+			Message: "inheritance is too deep",
+			Fields:  []FieldError{{Field: "extends", Message: fmt.Sprintf("chain %s exceeds limit of %d", strings.Join(chain, " -> "), r.maxDepth)}},
+		}
+	}
+
+	effective := &Metadata{}
+	for _, parentName := range cfg.Extends {
+		for _, seen := range chain {
+			if seen == parentName {
+				return nil, &ValidationError{
+					Message: "inheritance cycle detected",
+					Fields:  []FieldError{{Field: "extends", Message: strings.Join(append(chain, parentName), " -> ")}},
+				}
+			}
+		}
+
+		// checked before the lookup so that existence of parent is not revealed either
+		if r.readable != nil && !r.readable(parentName) {
+			return nil, &ValidationError{
+				Message: "parent configuration item is not readable",
+				Fields:  []FieldError{{Field: "extends", Message: fmt.Sprintf("%q is not readable", parentName)}},
+			}
+		}
+
+		parentMetadata, ok := r.resolved[parentName]
+		if !ok {
+			parent, err := r.lookup(parentName)
+			if err == nil && parent == nil {
+				err = sql.ErrNoRows
+			}
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, &ValidationError{
+						Message: "parent configuration item was not found",
+						Fields:  []FieldError{{Field: "extends", Message: fmt.Sprintf("%q does not exist", parentName)}},
+					}
+				}
+				return nil, err
+			}
+
+			parentMetadata, err = r.resolveChain(parent, append(chain[:len(chain):len(chain)], parentName))
+			if err != nil {
+				return nil, err
+			}
+			r.resolved[parentName] = parentMetadata
+		}
+
+		merged, err := mergeMetadata(effective, parentMetadata)
+		if err != nil {
+			return nil, err
+		}
+		effective = merged
+	}
+
+	return mergeMetadata(effective, cfg.Metadata)
+}
+
+// resolvedConfig returns copy of cfg with effective metadata
+func (r *configResolver) resolvedConfig(cfg *Config) (*Config, error) {
+	md, err := r.resolve(cfg)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *cfg
+	resolved.Metadata = md
+	return &resolved, nil
+}
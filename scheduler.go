@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type ScheduledChange struct {
+	ID          int        `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Metadata    *Metadata  `db:"metadata" json:"metadata"`
+	EffectiveAt time.Time  `db:"effective_at" json:"effective_at"`
+	Status      string     `db:"status" json:"status"`
+	Previous    *Metadata  `db:"previous" json:"previous,omitempty"`
+	Message     string     `db:"message" json:"message,omitempty"`
+	Created     time.Time  `db:"created_at" json:"-"`
+	AppliedAt   *time.Time `db:"applied_at" json:"applied_at,omitempty"`
+}
+
+const (
+	scheduleStatusPending   = "pending"
+	scheduleStatusApplied   = "applied"
+	scheduleStatusCancelled = "cancelled"
+	scheduleStatusFailed    = "failed"
+)
+
+const defaultSchedulerInterval = 10 * time.Second
+
+// InsertScheduledChange stores metadata change to be applied at EffectiveAt
+func (db *Database) InsertScheduledChange(chg *ScheduledChange) (int, error) {
+
+	// insert statement
+	stmt := `INSERT INTO pending_changes (name, metadata, effective_at, status, created_at) VALUES (?, ?, ?, ?, datetime('now'))`
+
+	// execute DML statement
+	result, err := db.execTx(stmt, chg.Name, chg.Metadata, chg.EffectiveAt.UTC(), scheduleStatusPending)
+	if err != nil {
+		return 0, err
+	}
+
+	// get newly created record id
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetScheduledChanges retrieves scheduled changes, all of them if name is empty
+func (db *Database) GetScheduledChanges(name string) (*[]ScheduledChange, error) {
+	stmt := `SELECT id, name, metadata, effective_at, status, previous, message, created_at, applied_at FROM pending_changes`
+
+	args := []interface{}{}
+	if name != "" {
+		stmt += ` WHERE name = ?`
+		args = append(args, name)
+	}
+	stmt += ` ORDER BY effective_at ASC, id ASC`
+
+	chgs := []ScheduledChange{}
+	if err := db.Select(&chgs, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	return &chgs, nil
+}
+
+// GetScheduledChange retrieves scheduled change by its id
+func (db *Database) GetScheduledChange(id int) (*ScheduledChange, error) {
+	stmt := `SELECT id, name, metadata, effective_at, status, previous, message, created_at, applied_at FROM pending_changes WHERE id = ?`
+
+	chg := &ScheduledChange{}
+	if err := db.Get(chg, stmt, id); err != nil {
+		return nil, err
+	}
+
+	return chg, nil
+}
+
+// CancelScheduledChange cancels pending change, sql.ErrNoRows is returned if there is nothing to cancel
+func (db *Database) CancelScheduledChange(id int) error {
+	stmt := `UPDATE pending_changes SET status = ? WHERE id = ? AND status = ?`
+
+	_, err := db.execTx(stmt, scheduleStatusCancelled, id, scheduleStatusPending)
+	return err
+}
+
+// ApplyScheduledChanges applies every pending change due at now, each one in its own transaction,
+// changes which cannot be applied are marked failed and do not hold up the rest
+func (db *Database) ApplyScheduledChanges(now time.Time, validate configValidator) (*[]ScheduledChange, error) {
+	stmt := `SELECT id FROM pending_changes WHERE status = ? AND effective_at <= ? ORDER BY effective_at ASC, id ASC`
+
+	ids := []int{}
+	if err := db.Select(&ids, stmt, scheduleStatusPending, now.UTC()); err != nil {
+		return nil, err
+	}
+
+	applied := []ScheduledChange{}
+	for _, id := range ids {
+		chg, err := db.applyScheduledChange(id, now, validate)
+		if err != nil {
+			// rolled back, keep it from being retried on every tick
+			if chg, err = db.failScheduledChange(id, now, err.Error()); err != nil {
+				return &applied, err
+			}
+		}
+		if chg != nil {
+			applied = append(applied, *chg)
+		}
+	}
+
+	return &applied, nil
+}
+
+// failScheduledChange marks pending change failed with message, nil is returned if it is not pending anymore
+func (db *Database) failScheduledChange(id int, now time.Time, message string) (*ScheduledChange, error) {
+	stmt := `UPDATE pending_changes SET status = ?, message = ?, applied_at = ? WHERE id = ? AND status = ?`
+	if _, err := db.execTx(stmt, scheduleStatusFailed, message, now.UTC(), id, scheduleStatusPending); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return db.GetScheduledChange(id)
+}
+
+// applyScheduledChange swaps config metadata and records previous state of it,
+// metadata is validated again as policy or schema might have changed since it was scheduled
+func (db *Database) applyScheduledChange(id int, now time.Time, validate configValidator) (chg *ScheduledChange, err error) {
+
+	// use transaction
+	tx, err := db.writer.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// rollback or commit
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// re-check status, change might have been cancelled in between
+	chg = &ScheduledChange{}
+	stmt := `SELECT id, name, metadata, effective_at, status, created_at FROM pending_changes WHERE id = ? AND status = ?`
+	if err = tx.Get(chg, stmt, id, scheduleStatusPending); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	appliedAt := now.UTC()
+	chg.AppliedAt = &appliedAt
+
+	// remember what is going to be replaced
+	current, err := getConfigTx(tx, chg.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		chg.Status = scheduleStatusFailed
+		chg.Message = "configuration item was not found"
+	case err != nil:
+		return nil, err
+	default:
+		next := &Config{Name: chg.Name, Metadata: chg.Metadata, Schema: current.Schema, Extends: current.Extends, Labels: current.Labels}
+		verr, err := validate(&dbTx{tx: tx}, next)
+		if err != nil {
+			return nil, err
+		}
+		if verr != nil {
+			chg.Status = scheduleStatusFailed
+			chg.Message = verr.Error()
+			break
+		}
+		if err = updateConfigTx(tx, current, next); err != nil {
+			return nil, err
+		}
+		chg.Status = scheduleStatusApplied
+		chg.Previous = current.Metadata
+
+		// audited along with the change itself
+		diff, err := diffMetadata(chg.Previous, chg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		entry := &AuditEntry{Time: appliedAt, Principal: schedulerPrincipal, Name: chg.Name, Diff: diff}
+		if err = recordAuditTx(tx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	stmt = `UPDATE pending_changes SET status = ?, previous = ?, message = ?, applied_at = ? WHERE id = ?`
+	if _, err = tx.Exec(stmt, chg.Status, chg.Previous, chg.Message, appliedAt, chg.ID); err != nil {
+		return nil, err
+	}
+
+	return chg, nil
+}
+
+// runScheduler periodically applies due changes until ctx is done
+func (srv *WebServer) runScheduler(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			srv.applyScheduledChanges(now)
+		}
+	}
+}
+
+// applyScheduledChanges applies due changes and logs the outcome
+func (srv *WebServer) applyScheduledChanges(now time.Time) {
+	chgs, err := srv.store.ApplyScheduledChanges(now, srv.validateConfigWith)
+	if err != nil {
+		srv.log.Info("Error applying scheduled changes", zap.Error(err))
+	}
+	if chgs == nil {
+		return
+	}
+	for _, chg := range *chgs {
+		srv.log.Info("Scheduled change processed",
+			zap.Int("id", chg.ID),
+			zap.String("name", chg.Name),
+			zap.String("status", chg.Status),
+			zap.String("message", chg.Message),
+		)
+		if chg.Status != scheduleStatusApplied {
+			continue
+		}
+
+		// recorded in the audit log along with the change, only mirrored here
+		diff, _ := diffMetadata(chg.Previous, chg.Metadata)
+		srv.mirrorAudit(&AuditEntry{
+			Time:      chg.AppliedAt.UTC(),
+			Principal: schedulerPrincipal,
+			Name:      chg.Name,
+			Diff:      redactDiff(diff),
+		})
+	}
+}
+
+// schedulesPostHandler handles POST /configs/abc/schedules
+func (srv *WebServer) schedulesPostHandler(w http.ResponseWriter, r *http.Request) {
+	var chg ScheduledChange
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&chg); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if chg.Metadata == nil || chg.EffectiveAt.IsZero() {
+		http.Error(w, "metadata and effective_at are required", http.StatusBadRequest)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	cfg, err := srv.store.GetConfigByName(name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "configuration item was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// scheduled metadata must satisfy policy and config schema as well
+	verr, err := srv.validateConfig(&Config{Name: name, Metadata: chg.Metadata, Schema: cfg.Schema})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	chg.Name = name
+	chg.Status = scheduleStatusPending
+
+	id, err := srv.storeFor(r).InsertScheduledChange(&chg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	chg.ID = id
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(chg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// schedulesGetAllHandler handles GET /schedules
+func (srv *WebServer) schedulesGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	chgs, err := srv.store.GetScheduledChanges(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	visible := make([]ScheduledChange, 0, len(*chgs))
+	for _, chg := range *chgs {
+		if !srv.allowed(r, chg.Name, roleReader) {
+			continue
+		}
+		if !srv.revealed(r, chg.Name) {
+			chg.Metadata, chg.Previous = redactMetadata(chg.Metadata), redactMetadata(chg.Previous)
+		}
+		visible = append(visible, chg)
+	}
+	chgs = &visible
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(chgs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// schedulesDeleteOneHandler handles DELETE /schedules/123
+func (srv *WebServer) schedulesDeleteOneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid scheduled change id: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	chg, err := srv.store.GetScheduledChange(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "pending scheduled change was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// cancelling takes editor role on config the change belongs to
+	if !srv.authorize(w, r, chg.Name, roleEditor) {
+		return
+	}
+
+	if err := srv.storeFor(r).CancelScheduledChange(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "pending scheduled change was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "scheduled change has successfully been cancelled")
+}
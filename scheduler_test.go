@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduledChanges(t *testing.T) {
+
+	t.Run("apply due", func(t *testing.T) {
+		cfgNew := &Config{
+			Name: "abc",
+			Metadata: &Metadata{
+				"limits": &Limits{
+					Cpu: Cpu{
+						Enabled: true,
+						Value:   "300m",
+					},
+				},
+			},
+		}
+
+		initDB := func(db *Database) error {
+			_, err := db.InsertConfig(cfgNew)
+			return err
+		}
+
+		effective := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/configs/abc/schedules",
+				body:   strings.NewReader(`{"metadata":{"limits":{"cpu":{"enabled":true,"value":"500m"}}},"effective_at":"` + effective + `"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusCreated)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs/missing/schedules",
+				body:   strings.NewReader(`{"metadata":{},"effective_at":"` + effective + `"}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusNotFound)
+				},
+			},
+		}
+
+		server, memStore := newTestServer(t, nil, initDB)
+
+		for _, pair := range testPairs {
+			req, res := prepareRequest(t, pair.method, pair.path, pair.body)
+			server.Handler.ServeHTTP(res, req)
+			pair.verifier(t, res)
+		}
+
+		// nothing is due yet
+		chgs, err := memStore.ApplyScheduledChanges(time.Now(), server.validateConfigWith)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*chgs) != 0 {
+			t.Errorf("expected %d applied changes but got %d", 0, len(*chgs))
+		}
+
+		chgs, err = memStore.ApplyScheduledChanges(time.Now().Add(2*time.Hour), server.validateConfigWith)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*chgs) != 1 {
+			t.Fatalf("expected %d applied changes but got %d", 1, len(*chgs))
+		}
+		if (*chgs)[0].Status != scheduleStatusApplied {
+			t.Errorf("expected status %q but got %q", scheduleStatusApplied, (*chgs)[0].Status)
+		}
+
+		req, res := prepareRequest(t, http.MethodGet, "/configs/abc", nil)
+		server.Handler.ServeHTTP(res, req)
+
+		got := res.Body.String()
+		want := `{"id":1,"name":"abc","metadata":{"limits":{"cpu":{"enabled":true,"value":"500m"}}}}`
+
+		assertResponseBody(t, got, want)
+
+		req, res = prepareRequest(t, http.MethodGet, "/schedules?name=abc", nil)
+		server.Handler.ServeHTTP(res, req)
+
+		if !strings.Contains(res.Body.String(), `"previous":{"limits":{"cpu":{"enabled":true,"value":"300m"}}}`) {
+			t.Errorf("expected previous metadata to be recorded, got %s", res.Body.String())
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		initDB := func(db *Database) error {
+			for _, name := range []string{"abc", "def", "ghi"} {
+				if _, err := db.InsertConfig(&Config{Name: name, Schema: "dc", Metadata: &Metadata{"zone": "a"}}); err != nil {
+					return err
+				}
+				if _, err := db.InsertScheduledChange(&ScheduledChange{Name: name, Metadata: &Metadata{"zone": "b"}, EffectiveAt: time.Now()}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		server, memStore := newTestServer(t, nil, initDB)
+
+		// schema got stricter after abc change was scheduled
+		err := memStore.InsertSchema(&Schema{Name: "dc", Document: []byte(`{"type":"object","properties":{"zone":{"type":"string","enum":["a","c"]}}}`)})
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		// def change errors out, ghi change has to be applied still
+		validate := func(reader configReader, cfg *Config) (*ValidationError, error) {
+			if cfg.Name == "def" {
+				return nil, errors.New("boom")
+			}
+			if cfg.Name == "ghi" {
+				return nil, nil
+			}
+			return server.validateConfigWith(reader, cfg)
+		}
+
+		chgs, err := memStore.ApplyScheduledChanges(time.Now().Add(time.Minute), validate)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*chgs) != 3 {
+			t.Fatalf("expected %d processed changes but got %d", 3, len(*chgs))
+		}
+
+		want := []struct{ status, message string }{
+			{scheduleStatusFailed, "metadata.zone"},
+			{scheduleStatusFailed, "boom"},
+			{scheduleStatusApplied, ""},
+		}
+		for i, chg := range *chgs {
+			if chg.Status != want[i].status || !strings.Contains(chg.Message, want[i].message) {
+				t.Errorf("%s: expected status %q with message %q but got %q with %q", chg.Name, want[i].status, want[i].message, chg.Status, chg.Message)
+			}
+		}
+
+		// failed changes are not retried
+		chgs, err = memStore.ApplyScheduledChanges(time.Now().Add(time.Minute), validate)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*chgs) != 0 {
+			t.Errorf("expected %d processed changes but got %d", 0, len(*chgs))
+		}
+
+		for name, zone := range map[string]string{"abc": "a", "def": "a", "ghi": "b"} {
+			cfg, err := memStore.GetConfigByName(name)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if got := (*cfg.Metadata)["zone"]; got != zone {
+				t.Errorf("%s: expected zone %q but got %v", name, zone, got)
+			}
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		initDB := func(db *Database) error {
+			createTable(t, db)
+			if _, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{}}); err != nil {
+				return err
+			}
+			_, err := db.InsertScheduledChange(&ScheduledChange{Name: "abc", Metadata: &Metadata{}, EffectiveAt: time.Now()})
+			return err
+		}
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodDelete,
+				path:   "/schedules/1",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+					assertResponseBody(t, res.Body.String(), "scheduled change has successfully been cancelled")
+				},
+			},
+			{
+				method: http.MethodDelete,
+				path:   "/schedules/1",
+				body:   nil,
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusNotFound)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, initDB, &testPairs)
+	})
+
+}
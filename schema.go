@@ -0,0 +1,511 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+type Schema struct {
+	Name     string          `json:"name"`
+	Document json.RawMessage `json:"document"`
+	Created  time.Time       `json:"-"`
+}
+
+// jsonSchema is a subset of JSON Schema draft-07 keywords sufficient to describe metadata shape,
+// annotations like $schema, title or description are ignored while other keywords are rejected
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                *json.RawMessage       `json:"const"`
+	Pattern              string                 `json:"pattern"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	pattern  *regexp.Regexp
+	constVal interface{}
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "null"] forms
+type schemaTypes []string
+
+// additionalProperties accepts both boolean and schema forms
+type additionalProperties struct {
+	Allowed bool
+	Schema  *jsonSchema
+}
+
+// schemaKeywords lists keywords jsonSchema understands or may safely ignore, validating against a schema
+// relying on anything else like $ref, allOf or format would silently accept everything
+var schemaKeywords = map[string]bool{
+	"type":                 true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"enum":                 true,
+	"const":                true,
+	"pattern":              true,
+	"minLength":            true,
+	"maxLength":            true,
+	"minimum":              true,
+	"maximum":              true,
+	"minItems":             true,
+	"maxItems":             true,
+	"$schema":              true,
+	"$id":                  true,
+	"$comment":             true,
+	"title":                true,
+	"description":          true,
+	"default":              true,
+	"examples":             true,
+}
+
+var jsonSchemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *schemaTypes) UnmarshalJSON(buf []byte) error {
+	var one string
+	if err := json.Unmarshal(buf, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(buf, &many); err != nil {
+		return errors.New("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *additionalProperties) UnmarshalJSON(buf []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(buf, &allowed); err == nil {
+		a.Allowed = allowed
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(buf, &a.Schema)
+}
+
+// compileSchema parses JSON Schema document and checks that it is usable
+func compileSchema(doc []byte) (*jsonSchema, error) {
+	if err := checkSchemaKeywords(doc, "#"); err != nil {
+		return nil, err
+	}
+
+	var s jsonSchema
+	if err := json.Unmarshal(doc, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema document: %w", err)
+	}
+	if err := s.compile("#"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// checkSchemaKeywords rejects keywords jsonSchema does not implement, recursively through subschemas
+func checkSchemaKeywords(doc []byte, ref string) error {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &keywords); err != nil {
+		// booleans and other malformed documents are reported by compileSchema
+		return nil
+	}
+
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !schemaKeywords[name] {
+			return fmt.Errorf("%s: unsupported keyword %q", ref, name)
+		}
+	}
+
+	if props, ok := keywords["properties"]; ok {
+		var subs map[string]json.RawMessage
+		if err := json.Unmarshal(props, &subs); err == nil {
+			for name, sub := range subs {
+				if err := checkSchemaKeywords(sub, ref+"/properties/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, name := range []string{"additionalProperties", "items"} {
+		if sub, ok := keywords[name]; ok {
+			if err := checkSchemaKeywords(sub, ref+"/"+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compile validates keywords and pre-compiles patterns recursively
+func (s *jsonSchema) compile(ref string) error {
+	for _, t := range s.Type {
+		if !jsonSchemaTypes[t] {
+			return fmt.Errorf("%s: unknown type %q", ref, t)
+		}
+	}
+
+	if s.Const != nil {
+		if err := json.Unmarshal(*s.Const, &s.constVal); err != nil {
+			return fmt.Errorf("%s: invalid const: %w", ref, err)
+		}
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", ref, err)
+		}
+		s.pattern = re
+	}
+
+	for name, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("%s/properties/%s: schema must be an object", ref, name)
+		}
+		if err := prop.compile(ref + "/properties/" + name); err != nil {
+			return err
+		}
+	}
+
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		if err := s.AdditionalProperties.Schema.compile(ref + "/additionalProperties"); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		if err := s.Items.compile(ref + "/items"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks value against schema, path is used to report offending fields
+func (s *jsonSchema) validate(v interface{}, path string) []FieldError {
+	var errs []FieldError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		fail("expected %s but got %s", strings.Join(s.Type, " or "), jsonTypeOf(v))
+		return errs
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the allowed values")
+		}
+	}
+
+	if s.Const != nil {
+		if !reflect.DeepEqual(s.constVal, v) {
+			fail("value must be equal to %s", string(*s.Const))
+		}
+	}
+
+	switch t := v.(type) {
+	case string:
+		length := len([]rune(t))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("length must be at least %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("length must be at most %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			fail("value does not match pattern %q", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && t < *s.Minimum {
+			fail("value must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && t > *s.Maximum {
+			fail("value must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			fail("must contain at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			fail("must contain at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for idx, item := range t {
+				errs = append(errs, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, idx))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				errs = append(errs, FieldError{Field: joinPath(path, name), Message: "field is required"})
+			}
+		}
+
+		// iterate in stable order to produce predictable error lists
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				errs = append(errs, prop.validate(t[k], joinPath(path, k))...)
+				continue
+			}
+			if s.AdditionalProperties == nil {
+				continue
+			}
+			if !s.AdditionalProperties.Allowed {
+				errs = append(errs, FieldError{Field: joinPath(path, k), Message: "field is not allowed"})
+				continue
+			}
+			if s.AdditionalProperties.Schema != nil {
+				errs = append(errs, s.AdditionalProperties.Schema.validate(t[k], joinPath(path, k))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// match reports whether value is one of the types
+func (t schemaTypes) match(v interface{}) bool {
+	actual := jsonTypeOf(v)
+	for _, want := range t {
+		if want == actual {
+			return true
+		}
+		if want == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf names JSON type of a decoded value
+func jsonTypeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// InsertSchema creates or replaces named schema document
+func (db *Database) InsertSchema(s *Schema) error {
+	stmt := `INSERT INTO schemas (name, document, created_at) VALUES (?, ?, datetime('now'))
+		ON CONFLICT(name) DO UPDATE SET document = excluded.document`
+
+	_, err := db.execTx(stmt, s.Name, string(s.Document))
+	return err
+}
+
+// GetSchema retrieves schema by its name
+func (db *Database) GetSchema(name string) (*Schema, error) {
+	return getSchema(db, name)
+}
+
+// getSchema retrieves schema by its name through database or transaction
+func getSchema(q sqlx.Queryer, name string) (*Schema, error) {
+	stmt := `SELECT name, document, created_at FROM schemas WHERE name = ?`
+
+	s := &Schema{}
+	var doc string
+
+	if err := q.QueryRowx(stmt, name).Scan(&s.Name, &doc, &s.Created); err != nil {
+		return nil, err
+	}
+	s.Document = json.RawMessage(doc)
+
+	return s, nil
+}
+
+// GetSchemas retrieves all schemas
+func (db *Database) GetSchemas() (*[]Schema, error) {
+	stmt := `SELECT name, document, created_at FROM schemas ORDER BY name ASC`
+
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []Schema{}
+	for rows.Next() {
+		var s Schema
+		var doc string
+		if err := rows.Scan(&s.Name, &doc, &s.Created); err != nil {
+			return nil, err
+		}
+		s.Document = json.RawMessage(doc)
+		schemas = append(schemas, s)
+	}
+
+	return &schemas, rows.Err()
+}
+
+// validateConfigSchema checks config metadata against schema declared by config, if any
+func (srv *WebServer) validateConfigSchema(reader configReader, cfg *Config) (*ValidationError, error) {
+	if cfg.Schema == "" {
+		return nil, nil
+	}
+
+	s, err := reader.GetSchema(cfg.Schema)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &ValidationError{
+				Message: fmt.Sprintf("schema %q was not found", cfg.Schema),
+				Fields:  []FieldError{{Field: "schema", Message: "unknown schema"}},
+			}, nil
+		}
+		return nil, err
+	}
+
+	compiled, err := compileSchema(s.Document)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := genericMetadata(cfg.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// schemas describe secret values themselves rather than objects wrapping them
+	md, _, err = rewriteSecrets(md, unwrapSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := compiled.validate(md, "metadata"); len(errs) > 0 {
+		return &ValidationError{
+			Message: fmt.Sprintf("metadata does not conform to schema %q", cfg.Schema),
+			Fields:  errs,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// schemasPostHandler handles POST /schemas/abc, replacing a schema does not revalidate configs already
+// declaring it, they are checked against the new document on their next write only
+func (srv *WebServer) schemasPostHandler(w http.ResponseWriter, r *http.Request) {
+	var doc json.RawMessage
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if _, err := compileSchema(doc); err != nil {
+		writeValidationError(w, &ValidationError{Message: err.Error()})
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+
+	if err := srv.storeFor(r).InsertSchema(&Schema{Name: name, Document: doc}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "schema has successfully been registered")
+}
+
+// schemasGetAllHandler handles GET /schemas
+func (srv *WebServer) schemasGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	schemas, err := srv.store.GetSchemas()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(schemas); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// schemasGetOneHandler handles GET /schemas/abc
+func (srv *WebServer) schemasGetOneHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := srv.store.GetSchema(mux.Vars(r)["name"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "schema was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
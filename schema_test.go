@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const datacenterSchema = `{
+	"type": "object",
+	"required": ["monitoring", "limits"],
+	"properties": {
+		"monitoring": {
+			"type": "object",
+			"required": ["enabled"],
+			"properties": {"enabled": {"type": "string", "enum": ["true", "false"]}}
+		},
+		"limits": {
+			"type": "object",
+			"properties": {
+				"cpu": {
+					"type": "object",
+					"additionalProperties": false,
+					"properties": {
+						"enabled": {"type": "string", "enum": ["true", "false"]},
+						"value": {"type": "string", "pattern": "^[0-9]+m$"}
+					}
+				}
+			}
+		}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+
+	compiled, err := compileSchema([]byte(datacenterSchema))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		var md interface{}
+		json.Unmarshal([]byte(`{"monitoring":{"enabled":"true"},"limits":{"cpu":{"enabled":"false","value":"300m"}}}`), &md)
+
+		if errs := compiled.validate(md, "metadata"); len(errs) != 0 {
+			t.Errorf("expected no errors but got %v", errs)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var md interface{}
+		json.Unmarshal([]byte(`{"monitoring":{"enabled":true},"limits":{"cpu":{"value":"300","extra":"1"}}}`), &md)
+
+		got := compiled.validate(md, "metadata")
+		want := []FieldError{
+			{Field: "metadata.limits.cpu.extra", Message: "field is not allowed"},
+			{Field: "metadata.limits.cpu.value", Message: `value does not match pattern "^[0-9]+m$"`},
+			{Field: "metadata.monitoring.enabled", Message: "expected string but got boolean"},
+		}
+
+		if !cmp.Equal(got, want) {
+			t.Errorf("Field errors received\n%s", cmp.Diff(want, got))
+		}
+	})
+
+	t.Run("broken schema", func(t *testing.T) {
+		if _, err := compileSchema([]byte(`{"type":"strin"}`)); err == nil {
+			t.Fatal("expected error, none thrown")
+		}
+	})
+
+	t.Run("unsupported keyword", func(t *testing.T) {
+		for doc, keyword := range map[string]string{
+			`{"$ref":"#/definitions/cpu"}`:                             `#: unsupported keyword "$ref"`,
+			`{"properties":{"cpu":{"anyOf":[{"type":"string"}]}}}`:     `#/properties/cpu: unsupported keyword "anyOf"`,
+			`{"items":{"type":"string","format":"uri"}}`:               `#/items: unsupported keyword "format"`,
+			`{"additionalProperties":{"patternProperties":{"^a":{}}}}`: `#/additionalProperties: unsupported keyword "patternProperties"`,
+		} {
+			if _, err := compileSchema([]byte(doc)); err == nil || err.Error() != keyword {
+				t.Errorf("%s: expected error %q but got %v", doc, keyword, err)
+			}
+		}
+
+		if _, err := compileSchema([]byte(`{"$schema":"http://json-schema.org/draft-07/schema#","title":"t","description":"d","type":"object"}`)); err != nil {
+			t.Errorf("expected annotations to be accepted but got %v", err)
+		}
+
+		srv, _ := newTestServer(t, nil)
+		res := getResponse(t, srv, http.MethodPost, "/schemas/dc", strings.NewReader(`{"type":"object","minProperties":1}`))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		if body := readBody(t, res); !strings.Contains(body, "minProperties") {
+			t.Errorf("expected unsupported keyword to be named but got %s", body)
+		}
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		srv, _ := newTestServer(t, map[string]string{"SERVE_METADATA_MAX_SIZE": "1024"})
+
+		huge := `{"type":"object","description":"` + strings.Repeat("x", 8*1024) + `"}`
+		res := getResponse(t, srv, http.MethodPost, "/schemas/dc", strings.NewReader(huge))
+		assertResponseCode(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	})
+
+}
+
+func TestPostConfigsWithSchema(t *testing.T) {
+
+	t.Run("rejected", func(t *testing.T) {
+		os.Setenv("SERVE_PORT", "8080")
+		defer os.Unsetenv("SERVE_PORT")
+
+		testPairs := []TestSubmitSequenceRequest{
+			{
+				method: http.MethodPost,
+				path:   "/schemas/datacenter",
+				body:   strings.NewReader(datacenterSchema),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs",
+				body:   strings.NewReader(`{"name":"dc","schema":"datacenter","metadata":{"monitoring":{}}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+
+					got := res.Body.String()
+					want := `{"error":"metadata does not conform to schema \"datacenter\"","fields":[{"field":"metadata.limits","message":"field is required"},{"field":"metadata.monitoring.enabled","message":"field is required"}]}`
+
+					assertResponseBody(t, got, want)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs",
+				body:   strings.NewReader(`{"name":"dc","schema":"datacenter","metadata":{"monitoring":{"enabled":"true"},"limits":{}}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusOK)
+				},
+			},
+			{
+				method: http.MethodPatch,
+				path:   "/configs/dc",
+				body:   strings.NewReader(`{"metadata":{"monitoring":{"enabled":"maybe"},"limits":{}}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+			{
+				method: http.MethodPost,
+				path:   "/configs",
+				body:   strings.NewReader(`{"name":"other","schema":"unknown","metadata":{}}`),
+				verifier: func(t *testing.T, res *httptest.ResponseRecorder) {
+					assertResponseCode(t, res.Code, http.StatusUnprocessableEntity)
+				},
+			},
+		}
+
+		submitSequenceRequestInMem(t, nil, &testPairs)
+	})
+
+}
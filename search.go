@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type searchFilter struct {
+	Path  []string
+	Value string
+}
+
+const searchMetadataPrefix = "metadata."
+
+// parseSearchFilters collects metadata.key=value query parameters, other parameters are ignored
+func parseSearchFilters(query url.Values) ([]searchFilter, error) {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if strings.HasPrefix(k, searchMetadataPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	filters := make([]searchFilter, 0, len(keys))
+	for _, k := range keys {
+		path := strings.Split(strings.TrimPrefix(k, searchMetadataPrefix), ".")
+		for _, p := range path {
+			if p == "" {
+				return nil, fmt.Errorf("invalid search key %q", k)
+			}
+		}
+		for _, v := range query[k] {
+			filters = append(filters, searchFilter{Path: path, Value: v})
+		}
+	}
+
+	return filters, nil
+}
+
+// matchMetadata reports whether metadata satisfies every filter
+func matchMetadata(md *Metadata, filters []searchFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+	// secret values are never matched, filters would otherwise disclose them
+	v, err := genericMetadata(redactMetadata(md))
+	if err != nil {
+		return false
+	}
+
+	for _, f := range filters {
+		leaf, ok := lookupPath(v, f.Path)
+		if !ok || !matchLeaf(leaf, f.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupPath descends into decoded JSON value following object keys
+func lookupPath(v interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// matchLeaf compares leaf value with query string, non-string scalars are compared by their JSON form
+func matchLeaf(leaf interface{}, want string) bool {
+	switch t := leaf.(type) {
+	case string:
+		return t == want
+	case bool:
+		return strconv.FormatBool(t) == want
+	case float64, nil:
+		buf, _ := json.Marshal(t)
+		return string(buf) == want
+	default:
+		return false
+	}
+}
+
+// searchConfigs filters configs, optionally matching and returning their effective or per-environment metadata,
+// effective metadata is merged only from readable parents
+func (srv *WebServer) searchConfigs(query url.Values, readable func(name string) bool) (*[]Config, error) {
+	filters, err := parseSearchFilters(query)
+	if err != nil {
+		return nil, &ValidationError{Message: err.Error()}
+	}
+
+	cfgs, err := srv.store.GetConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	var resolver *configResolver
+	if isTrue(query.Get("resolved")) {
+		resolver = srv.newListResolver(*cfgs, readable)
+	}
+
+	// environment overlays of every config at once
+	overlays := map[string]*Overlay{}
+	if env := query.Get("env"); env != "" {
+		ovrs, err := srv.store.GetOverlays("", env)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range *ovrs {
+			overlays[(*ovrs)[idx].Name] = &(*ovrs)[idx]
+		}
+	}
+
+	found := []Config{}
+	for _, cfg := range *cfgs {
+		if resolver != nil {
+			resolved, err := resolver.resolvedConfig(&cfg)
+			if err != nil {
+				// config with broken parents cannot match, it must not hide the rest
+				var verr *ValidationError
+				if errors.As(err, &verr) {
+					continue
+				}
+				return nil, err
+			}
+			cfg = *resolved
+		}
+		if ovr, ok := overlays[cfg.Name]; ok {
+			merged, err := applyOverlay(&cfg, ovr)
+			if err != nil {
+				return nil, err
+			}
+			cfg = *merged
+		}
+		if matchMetadata(cfg.Metadata, filters) {
+			found = append(found, cfg)
+		}
+	}
+
+	return &found, nil
+}
+
+// isTrue interprets query flag value
+func isTrue(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+// searchGetHandler handles GET /search
+func (srv *WebServer) searchGetHandler(w http.ResponseWriter, r *http.Request) {
+	if err := srv.waitForChanges(r); err != nil {
+		writeBlockingError(w, err)
+		return
+	}
+	srv.setChangesIndex(w)
+
+	// search without any criteria answers as it did before filters were supported
+	if len(r.URL.Query()) == 0 {
+		fmt.Fprint(w, "Search GET!")
+		return
+	}
+
+	cfgs, err := srv.searchConfigs(r.URL.Query(), srv.readable(r))
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			writeValidationError(w, verr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfgs = srv.redactConfigs(r, srv.filterConfigs(r, cfgs))
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(cfgs); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Keyring holds key encryption keys, the first one seals new values while the rest only open values
+// sealed before rotation
+type Keyring struct {
+	keys []keyringKey
+}
+
+type keyringKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+const (
+	secretKey         = "$secret"
+	encryptedKey      = "$encrypted"
+	redactedSecret    = "[REDACTED]"
+	sealedFormat      = "v1"
+	keyringKeySize    = 32
+	keyringKeyIDBytes = 4
+)
+
+// secretKeyring seals secret metadata values at rest, it is package state as Metadata Value and Scan
+// have no other way to reach it, nil when no key file is configured
+var secretKeyring *Keyring
+
+var errNoKeyring = errors.New("secret values require SERVE_SECRETS_KEY_FILE to be configured")
+
+// metadataSecretColumns lists columns holding metadata documents, change log keeps whole configs
+var metadataSecretColumns = []struct{ table, column string }{
+	{"configs", "metadata"},
+	{"config_history", "metadata"},
+	{"config_overlays", "metadata"},
+	{"overlay_history", "metadata"},
+	{"pending_changes", "metadata"},
+	{"pending_changes", "previous"},
+	{"changes", "config"},
+}
+
+// loadSecretKeyring reads key file named by SERVE_SECRETS_KEY_FILE, secrets are unavailable without one
+func loadSecretKeyring() error {
+	path := getStringOrDefault("SERVE_SECRETS_KEY_FILE", "")
+	if path == "" {
+		secretKeyring = nil
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return fmt.Errorf("line %q is not base64 encoded: %w", line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	keyring, err := newKeyring(keys...)
+	if err != nil {
+		return err
+	}
+	secretKeyring = keyring
+	return nil
+}
+
+// newKeyring creates keyring sealing with the first key, keys are identified by prefix of their hash
+func newKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key file contains no keys")
+	}
+
+	k := &Keyring{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if len(key) != keyringKeySize {
+			return nil, fmt.Errorf("keys must be %d bytes long but got %d", keyringKeySize, len(key))
+		}
+
+		sum := sha256.Sum256(key)
+		id := hex.EncodeToString(sum[:keyringKeyIDBytes])
+		if seen[id] {
+			return nil, fmt.Errorf("key %s is listed more than once", id)
+		}
+		seen[id] = true
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, keyringKey{id: id, aead: aead})
+	}
+	return k, nil
+}
+
+// newAEAD creates AES-256-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts value with fresh data key which is in turn encrypted with the primary key,
+// result is "v1:<key id>:<wrapped data key>:<ciphertext>"
+func (k *Keyring) seal(value string) (string, error) {
+	primary := k.keys[0]
+
+	dek := make([]byte, keyringKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := encrypt(primary.aead, dek, []byte(primary.id))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := encrypt(aead, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return strings.Join([]string{sealedFormat, primary.id, enc.EncodeToString(wrapped), enc.EncodeToString(ciphertext)}, ":"), nil
+}
+
+// open decrypts value produced by seal with whichever key sealed it
+func (k *Keyring) open(sealed string) (string, error) {
+	parts := strings.Split(sealed, ":")
+	if len(parts) != 4 || parts[0] != sealedFormat {
+		return "", errors.New("malformed encrypted secret")
+	}
+
+	var kek cipher.AEAD
+	for _, key := range k.keys {
+		if key.id == parts[1] {
+			kek = key.aead
+		}
+	}
+	if kek == nil {
+		return "", fmt.Errorf("secret is sealed with key %s which is not in the key file", parts[1])
+	}
+
+	enc := base64.RawStdEncoding
+	wrapped, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := decrypt(kek, wrapped, []byte(parts[1]))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	value, err := decrypt(aead, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// stale reports whether sealed value was not sealed with the primary key
+func (k *Keyring) stale(sealed string) bool {
+	parts := strings.SplitN(sealed, ":", 3)
+	return len(parts) < 2 || parts[1] != k.keys[0].id
+}
+
+// encrypt seals plaintext prefixing it with random nonce
+func encrypt(aead cipher.AEAD, plaintext, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+// decrypt opens ciphertext produced by encrypt
+func decrypt(aead cipher.AEAD, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// secretLeaf returns content of {"$secret": ...} or {"$encrypted": ...} object
+func secretLeaf(v interface{}) (key string, value interface{}, ok bool) {
+	m, isMap := v.(map[string]interface{})
+	if !isMap || len(m) != 1 {
+		return "", nil, false
+	}
+	for k, v := range m {
+		if k == secretKey || k == encryptedKey {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
+
+// rewriteSecrets returns copy of decoded JSON value with secret leaves replaced by fn, containers without
+// secrets are shared with v
+func rewriteSecrets(v interface{}, fn func(key string, value interface{}) (interface{}, error)) (interface{}, bool, error) {
+	if key, value, ok := secretLeaf(v); ok {
+		replaced, err := fn(key, value)
+		return replaced, true, err
+	}
+
+	switch t := v.(type) {
+	case Metadata:
+		return rewriteSecrets(map[string]interface{}(t), fn)
+	case map[string]interface{}:
+		var out map[string]interface{}
+		for k, item := range t {
+			replaced, changed, err := rewriteSecrets(item, fn)
+			if err != nil {
+				return nil, false, err
+			}
+			if !changed {
+				continue
+			}
+			if out == nil {
+				out = make(map[string]interface{}, len(t))
+				for k, item := range t {
+					out[k] = item
+				}
+			}
+			out[k] = replaced
+		}
+		if out == nil {
+			return v, false, nil
+		}
+		return out, true, nil
+	case []interface{}:
+		var out []interface{}
+		for idx, item := range t {
+			replaced, changed, err := rewriteSecrets(item, fn)
+			if err != nil {
+				return nil, false, err
+			}
+			if !changed {
+				continue
+			}
+			if out == nil {
+				out = append([]interface{}{}, t...)
+			}
+			out[idx] = replaced
+		}
+		if out == nil {
+			return v, false, nil
+		}
+		return out, true, nil
+	default:
+		return v, false, nil
+	}
+}
+
+// rewriteMetadata applies rewriteSecrets to metadata, md itself is returned when there is nothing to rewrite
+func rewriteMetadata(md *Metadata, fn func(key string, value interface{}) (interface{}, error)) (*Metadata, error) {
+	if md == nil {
+		return nil, nil
+	}
+	v, changed, err := rewriteSecrets(map[string]interface{}(*md), fn)
+	if err != nil || !changed {
+		return md, err
+	}
+	out := Metadata(v.(map[string]interface{}))
+	return &out, nil
+}
+
+// sealSecret turns {"$secret": value} into {"$encrypted": sealed}
+func sealSecret(key string, value interface{}) (interface{}, error) {
+	if key == encryptedKey {
+		return map[string]interface{}{encryptedKey: value}, nil
+	}
+	if secretKeyring == nil {
+		return nil, errNoKeyring
+	}
+	plain, ok := value.(string)
+	if !ok {
+		return nil, errors.New("secret value must be a string")
+	}
+	sealed, err := secretKeyring.seal(plain)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{encryptedKey: sealed}, nil
+}
+
+// openSecret turns {"$encrypted": sealed} into {"$secret": value}
+func openSecret(key string, value interface{}) (interface{}, error) {
+	if key == secretKey {
+		return map[string]interface{}{secretKey: value}, nil
+	}
+	if secretKeyring == nil {
+		return nil, errNoKeyring
+	}
+	sealed, _ := value.(string)
+	plain, err := secretKeyring.open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{secretKey: plain}, nil
+}
+
+// redactSecret hides value of secret leaf
+func redactSecret(string, interface{}) (interface{}, error) {
+	return map[string]interface{}{secretKey: redactedSecret}, nil
+}
+
+// unwrapSecret replaces secret leaf with its value
+func unwrapSecret(_ string, value interface{}) (interface{}, error) {
+	return value, nil
+}
+
+// sealMetadata returns copy of md with secret values encrypted
+func sealMetadata(md *Metadata) (*Metadata, error) {
+	return rewriteMetadata(md, sealSecret)
+}
+
+// openMetadata decrypts secret values of md in place
+func openMetadata(md *Metadata) error {
+	opened, err := rewriteMetadata(md, openSecret)
+	if err != nil || opened == md {
+		return err
+	}
+	*md = *opened
+	return nil
+}
+
+// redactMetadata returns copy of md with secret values hidden
+func redactMetadata(md *Metadata) *Metadata {
+	redacted, _ := rewriteMetadata(md, redactSecret)
+	return redacted
+}
+
+// checkSecret validates {"$secret": ...} object found at path of submitted metadata
+func checkSecret(m map[string]interface{}, path string) []FieldError {
+	if _, ok := m[encryptedKey]; ok {
+		return []FieldError{{Field: path, Message: fmt.Sprintf("key %q is reserved for values encrypted at rest", encryptedKey)}}
+	}
+	value, ok := m[secretKey]
+	if !ok {
+		return nil
+	}
+
+	switch s, isString := value.(string); {
+	case len(m) != 1:
+		return []FieldError{{Field: path, Message: fmt.Sprintf("secret object must have %q as its only key", secretKey)}}
+	case !isString:
+		return []FieldError{{Field: path, Message: fmt.Sprintf("secret value must be a string but got %s", jsonTypeOf(value))}}
+	case s == redactedSecret:
+		return []FieldError{{Field: path, Message: "redacted secret cannot be stored, send the secret value or leave the key out"}}
+	case secretKeyring == nil:
+		return []FieldError{{Field: path, Message: errNoKeyring.Error()}}
+	}
+	return nil
+}
+
+// revealed reports whether caller asked for secret values of config named name and is allowed to see them
+func (srv *WebServer) revealed(r *http.Request, name string) bool {
+	return isTrue(r.URL.Query().Get("reveal")) && srv.allowed(r, name, roleRevealer)
+}
+
+// revealable returns filter of config names caller is going to see secret values of
+func (srv *WebServer) revealable(r *http.Request) func(name string) bool {
+	return func(name string) bool {
+		return srv.revealed(r, name)
+	}
+}
+
+// redactedConfig returns copy of cfg with secret values hidden
+func redactedConfig(cfg *Config) *Config {
+	if cfg == nil {
+		return nil
+	}
+	redacted := *cfg
+	redacted.Metadata = redactMetadata(cfg.Metadata)
+	return &redacted
+}
+
+// redactConfig returns copy of cfg with secret values hidden unless caller may see them
+func (srv *WebServer) redactConfig(r *http.Request, cfg *Config) *Config {
+	if cfg == nil || srv.revealed(r, cfg.Name) {
+		return cfg
+	}
+	return redactedConfig(cfg)
+}
+
+// redactConfigs applies redactConfig to every config
+func (srv *WebServer) redactConfigs(r *http.Request, cfgs *[]Config) *[]Config {
+	out := make([]Config, 0, len(*cfgs))
+	for idx := range *cfgs {
+		out = append(out, *srv.redactConfig(r, &(*cfgs)[idx]))
+	}
+	return &out
+}
+
+// redactEvent hides secret values carried by event, reveal decides per config
+func redactEvent(event ConfigEvent, reveal func(name string) bool) ConfigEvent {
+	if reveal == nil || !reveal(event.Name) {
+		event.Config = redactedConfig(event.Config)
+	}
+	return event
+}
+
+// redactDiff hides secret values of metadata changes
+func redactDiff(diff []MetadataChange) []MetadataChange {
+	var out []MetadataChange
+	for idx, change := range diff {
+		before, beforeChanged, _ := rewriteSecrets(change.Old, redactSecret)
+		after, afterChanged, _ := rewriteSecrets(change.New, redactSecret)
+		secret := strings.HasSuffix(change.Path, "."+secretKey) || change.Path == secretKey
+		if !beforeChanged && !afterChanged && !secret {
+			continue
+		}
+		if out == nil {
+			out = append([]MetadataChange{}, diff...)
+		}
+		if secret {
+			if before != nil {
+				before = redactedSecret
+			}
+			if after != nil {
+				after = redactedSecret
+			}
+		}
+		out[idx].Old, out[idx].New = before, after
+	}
+	if out == nil {
+		return diff
+	}
+	return out
+}
+
+// RotateSecrets re-encrypts secret values sealed with keys other than the primary one, returns number of them
+func (db *Database) RotateSecrets() (int, error) {
+	if secretKeyring == nil {
+		return 0, errNoKeyring
+	}
+
+	rotated := 0
+	reseal := func(key string, value interface{}) (interface{}, bool, error) {
+		sealed, _ := value.(string)
+		if key != encryptedKey || !secretKeyring.stale(sealed) {
+			return map[string]interface{}{key: value}, false, nil
+		}
+		plain, err := secretKeyring.open(sealed)
+		if err != nil {
+			return nil, false, err
+		}
+		rotated++
+		replaced, err := sealSecret(secretKey, plain)
+		return replaced, true, err
+	}
+
+	err := db.inTx(func(tx *sqlx.Tx) error {
+		for _, col := range metadataSecretColumns {
+			if err := resealColumn(tx, col.table, col.column, reseal); err != nil {
+				return fmt.Errorf("%s.%s: %w", col.table, col.column, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rotated, nil
+}
+
+// resealColumn rewrites encrypted values of JSON documents stored in column of table
+func resealColumn(tx *sqlx.Tx, table, column string, reseal func(string, interface{}) (interface{}, bool, error)) error {
+	var rows []struct {
+		ID  int64  `db:"row_id"`
+		Doc string `db:"doc"`
+	}
+	stmt := fmt.Sprintf(`SELECT rowid AS row_id, %s AS doc FROM %s WHERE %s LIKE '%%"$encrypted"%%'`, column, table, column)
+	if err := tx.Select(&rows, stmt); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		var doc interface{}
+		decoder := json.NewDecoder(bytes.NewReader([]byte(row.Doc)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+
+		stale := false
+		resealed, _, err := rewriteSecrets(doc, func(key string, value interface{}) (interface{}, error) {
+			replaced, resealed, err := reseal(key, value)
+			stale = stale || resealed
+			return replaced, err
+		})
+		if err != nil {
+			return err
+		}
+		if !stale {
+			continue
+		}
+
+		buf, err := json.Marshal(resealed)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column), string(buf), row.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretsRotatePostHandler handles POST /admin/secrets/rotate
+func (srv *WebServer) secretsRotatePostHandler(w http.ResponseWriter, r *http.Request) {
+	rotated, err := srv.storeFor(r).RotateSecrets()
+	if err != nil {
+		if errors.Is(err, errNoKeyring) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "%d secret values have successfully been re-encrypted", rotated)
+}
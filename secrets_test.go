@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKey returns 32 byte key filled with b
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keyringKeySize)
+}
+
+// useKeyring installs keyring made of keys for the duration of test
+func useKeyring(t *testing.T, keys ...[]byte) *Keyring {
+	t.Helper()
+
+	keyring, err := newKeyring(keys...)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	secretKeyring = keyring
+	t.Cleanup(func() { secretKeyring = nil })
+	return keyring
+}
+
+// rawColumn concatenates stored values of column, the way they are kept at rest
+func rawColumn(t *testing.T, db *Database, table, column string) string {
+	t.Helper()
+
+	var values []string
+	if err := db.Select(&values, `SELECT `+column+` FROM `+table); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return strings.Join(values, "\n")
+}
+
+func TestKeyring(t *testing.T) {
+	old, err := newKeyring(testKey(1))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	sealed, err := old.seal("hunter2")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if strings.Contains(sealed, "hunter2") || !strings.HasPrefix(sealed, sealedFormat+":"+old.keys[0].id+":") {
+		t.Errorf("unexpected sealed value %q", sealed)
+	}
+
+	rotated, err := newKeyring(testKey(2), testKey(1))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if plain, err := rotated.open(sealed); err != nil || plain != "hunter2" {
+		t.Errorf("expected value sealed with old key to open but got %q, %v", plain, err)
+	}
+	if !rotated.stale(sealed) || old.stale(sealed) {
+		t.Error("expected value to be stale for rotated keyring only")
+	}
+
+	other, _ := newKeyring(testKey(3))
+	if _, err := other.open(sealed); err == nil || !strings.Contains(err.Error(), "not in the key file") {
+		t.Errorf("expected unknown key error but got %v", err)
+	}
+
+	parts := strings.Split(sealed, ":")
+	parts[3] = base64.RawStdEncoding.EncodeToString(bytes.Repeat([]byte{0}, 40))
+	if _, err := old.open(strings.Join(parts, ":")); err == nil {
+		t.Error("expected tampered value to be rejected")
+	}
+
+	if _, err := newKeyring(testKey(1), testKey(1)); err == nil {
+		t.Error("expected duplicate key to be rejected")
+	}
+	if _, err := newKeyring([]byte("short")); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+
+	file := filepath.Join(t.TempDir(), "keys")
+	content := "# primary\n" + base64.StdEncoding.EncodeToString(testKey(2)) + "\n\n" + base64.StdEncoding.EncodeToString(testKey(1)) + "\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	os.Setenv("SERVE_SECRETS_KEY_FILE", file)
+	defer os.Unsetenv("SERVE_SECRETS_KEY_FILE")
+	defer func() { secretKeyring = nil }()
+
+	if err := loadSecretKeyring(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(secretKeyring.keys) != 2 || secretKeyring.keys[0].id != rotated.keys[0].id {
+		t.Errorf("unexpected keyring loaded from file %+v", secretKeyring.keys)
+	}
+}
+
+func TestSecretsValidation(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+
+	// nothing can be sealed without key file
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"password":{"$secret":"hunter2"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+
+	useKeyring(t, testKey(1))
+
+	for _, body := range []string{
+		`{"name":"abc","metadata":{"password":{"$secret":42}}}`,
+		`{"name":"abc","metadata":{"password":{"$secret":"hunter2","other":"x"}}}`,
+		`{"name":"abc","metadata":{"password":{"$secret":"[REDACTED]"}}}`,
+		`{"name":"abc","metadata":{"password":{"$encrypted":"v1:00000000:AA:AA"}}}`,
+	} {
+		res := getResponse(t, srv, "POST", "/configs", strings.NewReader(body))
+		assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+	}
+
+	// schemas see secret values themselves
+	res = getResponse(t, srv, "POST", "/schemas/creds", strings.NewReader(`{"type":"object","properties":{"password":{"type":"string","minLength":8}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","schema":"creds","metadata":{"password":{"$secret":"short"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+	res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","schema":"creds","metadata":{"password":{"$secret":"hunter2hunter2"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+}
+
+func TestSecretsAtRest(t *testing.T) {
+	useKeyring(t, testKey(1))
+
+	srv, db := newTestServer(t, nil)
+
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"user":"app","password":{"$secret":"hunter2"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	res = getResponse(t, srv, "PATCH", "/configs/abc", strings.NewReader(`{"metadata":{"user":"app","password":{"$secret":"swordfish"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+	for _, col := range metadataSecretColumns {
+		raw := rawColumn(t, db, col.table, `COALESCE(`+col.column+`, '')`)
+		if strings.Contains(raw, "hunter2") || strings.Contains(raw, "swordfish") {
+			t.Errorf("expected %s.%s to keep secrets encrypted but got %s", col.table, col.column, raw)
+		}
+	}
+	if raw := rawColumn(t, db, "configs", "metadata"); !strings.Contains(raw, encryptedKey) || !strings.Contains(raw, `"user":"app"`) {
+		t.Errorf("expected only secret values to be encrypted but got %s", raw)
+	}
+	if raw := rawColumn(t, db, "audit_log", "diff"); strings.Contains(raw, "hunter2") || strings.Contains(raw, "swordfish") {
+		t.Errorf("expected audit log to hide secret values but got %s", raw)
+	}
+
+	// redacted unless explicitly revealed
+	for _, path := range []string{"/configs/abc", "/configs", "/search?metadata.user=app", "/changes", "/export", "/configs/abc/history"} {
+		body := readBody(t, getResponse(t, srv, "GET", path, nil))
+		if strings.Contains(body, "swordfish") || strings.Contains(body, "hunter2") || !strings.Contains(body, redactedSecret) {
+			t.Errorf("%s: expected secret to be redacted but got %s", path, body)
+		}
+	}
+
+	res = getResponse(t, srv, "GET", "/configs/abc?reveal=true", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	var cfg Config
+	if err := json.NewDecoder(res.Body).Decode(&cfg); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if secret, ok := (*cfg.Metadata)["password"].(map[string]interface{}); !ok || secret[secretKey] != "swordfish" {
+		t.Errorf("expected revealed secret but got %+v", *cfg.Metadata)
+	}
+
+	// secret values cannot be probed with search filters
+	if body := readBody(t, getResponse(t, srv, "GET", "/search?metadata.password.$secret=swordfish", nil)); strings.Contains(body, "abc") {
+		t.Errorf("expected search not to match secret value but got %s", body)
+	}
+
+	// redacted values cannot be written back
+	res = getResponse(t, srv, "PUT", "/configs/abc", strings.NewReader(`{"metadata":{"password":{"$secret":"[REDACTED]"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+}
+
+func TestSecretsRotation(t *testing.T) {
+	oldKeyring := useKeyring(t, testKey(1))
+
+	srv, db := newTestServer(t, nil)
+
+	res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"password":{"$secret":"hunter2"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	res = getResponse(t, srv, "PUT", "/configs/abc/overlays/prod", strings.NewReader(`{"metadata":{"password":{"$secret":"swordfish"}}}`))
+	assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+	newKeyring := useKeyring(t, testKey(2), testKey(1))
+	oldID, newID := oldKeyring.keys[0].id, newKeyring.keys[0].id
+
+	res = getResponse(t, srv, "POST", "/admin/secrets/rotate", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if body := readBody(t, res); !strings.HasPrefix(body, "4 secret values") {
+		t.Errorf("expected config, overlay and both change log values to be rotated but got %q", body)
+	}
+
+	for _, col := range metadataSecretColumns {
+		raw := rawColumn(t, db, col.table, `COALESCE(`+col.column+`, '')`)
+		if strings.Contains(raw, ":"+oldID+":") {
+			t.Errorf("expected %s.%s to be sealed with new key but got %s", col.table, col.column, raw)
+		}
+	}
+	if raw := rawColumn(t, db, "configs", "metadata"); !strings.Contains(raw, ":"+newID+":") {
+		t.Errorf("expected config to be sealed with new key but got %s", raw)
+	}
+
+	// old key can be dropped once values are rotated
+	useKeyring(t, testKey(2))
+	if srv.cache != nil {
+		srv.cache.invalidate()
+	}
+
+	res = getResponse(t, srv, "GET", "/configs/abc?reveal=true&env=prod", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if body := readBody(t, res); !strings.Contains(body, "swordfish") {
+		t.Errorf("expected secret to be readable with new key only but got %s", body)
+	}
+
+	res = getResponse(t, srv, "POST", "/admin/secrets/rotate", nil)
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if body := readBody(t, res); !strings.HasPrefix(body, "0 secret values") {
+		t.Errorf("expected nothing left to rotate but got %q", body)
+	}
+}
+
+func TestSecretsRevealPermission(t *testing.T) {
+	useKeyring(t, testKey(1))
+
+	srv, _ := newTestServer(t, authEnabled)
+
+	_, adminKey, err := srv.createAPIKey(srv.store, "ops", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	_, appKey, err := srv.createAPIKey(srv.store, "app", false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	for _, b := range []RoleBinding{
+		{Subject: "api_key:app", Role: roleReader, Pattern: "*"},
+		{Subject: "api_key:app", Role: roleRevealer, Pattern: "app-*"},
+	} {
+		b := b
+		if _, err := srv.store.InsertRoleBinding(&b); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	for _, name := range []string{"app-db", "other-db"} {
+		body := `{"name":"` + name + `","metadata":{"password":{"$secret":"` + name + `-secret"}}}`
+		res := getResponse(t, srv, "POST", "/configs", strings.NewReader(body), apiKeyHeader, adminKey)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	}
+
+	body := readBody(t, getResponse(t, srv, "GET", "/configs?reveal=true", nil, apiKeyHeader, appKey))
+	if !strings.Contains(body, "app-db-secret") || strings.Contains(body, "other-db-secret") {
+		t.Errorf("expected secrets of app-* configs only to be revealed but got %s", body)
+	}
+
+	// reveal has to be asked for
+	body = readBody(t, getResponse(t, srv, "GET", "/configs/app-db", nil, apiKeyHeader, appKey))
+	if strings.Contains(body, "app-db-secret") {
+		t.Errorf("expected secret to stay redacted without reveal but got %s", body)
+	}
+
+	// revealer role does not grant anything else
+	res := getResponse(t, srv, "PATCH", "/configs/app-db", strings.NewReader(`{"metadata":{"x":"y"}}`), apiKeyHeader, appKey)
+	assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+	body = readBody(t, getResponse(t, srv, "GET", "/configs/other-db?reveal=true", nil, apiKeyHeader, adminKey))
+	if !strings.Contains(body, "other-db-secret") {
+		t.Errorf("expected admin to see secret but got %s", body)
+	}
+}
+
+func TestSecretsRenderedReferences(t *testing.T) {
+	useKeyring(t, testKey(1))
+
+	srv, _ := newTestServer(t, authEnabled)
+
+	_, adminKey, err := srv.createAPIKey(srv.store, "ops", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	_, appKey, err := srv.createAPIKey(srv.store, "app", false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	for _, b := range []RoleBinding{
+		{Subject: "api_key:app", Role: roleReader, Pattern: "*"},
+		{Subject: "api_key:app", Role: roleRevealer, Pattern: "app-*"},
+	} {
+		b := b
+		if _, err := srv.store.InsertRoleBinding(&b); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	for _, body := range []string{
+		`{"name":"db","metadata":{"password":{"$secret":"s3cret"}}}`,
+		`{"name":"app-raw","metadata":{"password":"${ref:db#password.$secret}"}}`,
+		`{"name":"app-whole","metadata":{"password":"${ref:db#password}"}}`,
+		`{"name":"app-dsn","metadata":{"dsn":"postgres://u:${ref:db#password}@h"}}`,
+	} {
+		res := getResponse(t, srv, "POST", "/configs", strings.NewReader(body), apiKeyHeader, adminKey)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+	}
+
+	// secret leaves cannot be read into
+	res := getResponse(t, srv, "GET", "/configs/app-raw?render=true", nil, apiKeyHeader, appKey)
+	assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+
+	for _, path := range []string{
+		"/configs/app-whole?render=true",
+		"/configs/app-dsn?render=true",
+		"/configs/app-dsn?render=true&reveal=true",
+		"/configs?render=true",
+		"/configs?render=true&reveal=true",
+	} {
+		body := readBody(t, getResponse(t, srv, "GET", path, nil, apiKeyHeader, appKey))
+		if strings.Contains(body, "s3cret") {
+			t.Errorf("expected secret of db to stay redacted in %s but got %s", path, body)
+		}
+	}
+
+	// string with embedded secret is secret as a whole
+	body := readBody(t, getResponse(t, srv, "GET", "/configs/app-dsn?render=true&reveal=true", nil, apiKeyHeader, adminKey))
+	if !strings.Contains(body, `"$secret":"postgres://u:s3cret@h"`) {
+		t.Errorf("expected rendered dsn to be revealed as a secret but got %s", body)
+	}
+	body = readBody(t, getResponse(t, srv, "GET", "/configs/app-dsn?render=true", nil, apiKeyHeader, adminKey))
+	if strings.Contains(body, "s3cret") || strings.Contains(body, "postgres://") {
+		t.Errorf("expected rendered dsn to be redacted as a whole but got %s", body)
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,8 +18,24 @@ import (
 )
 
 type WebServer struct {
-	log   *zap.Logger
-	store DatabaseStore
+	log      *zap.Logger
+	store    DatabaseStore
+	metadata *MetadataPolicy
+
+	extendsMaxDepth int
+	renderEnv       map[string]bool
+
+	backupDir  string
+	backupKeep int
+	backupMu   sync.Mutex
+
+	events   *EventBus
+	cache    *CachedStore
+	webhooks *WebhookPolicy
+	auth     *AuthPolicy
+	rbac     *RBACPolicy
+	auditLog bool
+	stopping chan struct{}
 	http.Server
 }
 
@@ -34,8 +52,14 @@ func startServer() int {
 	}
 	defer closeDB()
 
+	// serve reads from memory unless cache is disabled
+	var store DatabaseStore = db
+	if size := getIntOrDefault("SERVE_CACHE_SIZE", defaultCacheSize); size > 0 {
+		store = NewCachedStore(db, size, time.Duration(getIntOrDefault("SERVE_CACHE_TTL", defaultCacheTTL))*time.Second)
+	}
+
 	// init web-server struct
-	server, err := NewWebServer(db)
+	server, err := NewWebServer(store)
 	if err != nil {
 		fmt.Println("Error: unable to initialize web-server:", err)
 		return 1
@@ -57,6 +81,25 @@ func startServer() int {
 		return nil
 	})
 
+	// apply scheduled changes in background
+	interval := time.Duration(getIntOrDefault("SERVE_SCHEDULER_INTERVAL", 0)) * time.Second
+	if interval <= 0 {
+		interval = defaultSchedulerInterval
+	}
+	errGroup.Go(func() error {
+		return server.runScheduler(ctx, interval)
+	})
+
+	// forget positions of abandoned websocket subscriptions in background
+	errGroup.Go(func() error {
+		return server.runWsCursorExpiry(ctx, time.Duration(getIntOrDefault("SERVE_WS_CURSOR_TTL", defaultWsCursorTTL))*time.Second)
+	})
+
+	// deliver webhooks in background
+	errGroup.Go(func() error {
+		return server.runWebhooks(ctx, defaultWebhookInterval)
+	})
+
 	// run server
 	if err := server.Start(); err != nil {
 		server.log.Info("Error starting the server", zap.Error(err))
@@ -72,9 +115,19 @@ func startServer() int {
 // Start starts web-server
 func (srv *WebServer) Start() error {
 	srv.log.Info("Starting the server", zap.String("address", srv.Addr))
+	if !srv.auth.Enabled {
+		srv.log.Warn("Authentication is disabled, every endpoint is open")
+	}
 
-	// start to listen for inbound connections
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	// start to listen for inbound connections, certificates come from TLS config
+	var err error
+	if srv.TLSConfig != nil {
+		srv.log.Info("Serving TLS", zap.Uint16("min_version", srv.TLSConfig.MinVersion))
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("error starting the server: %w", err)
 	}
 	return nil
@@ -107,6 +160,28 @@ func NewWebServer(store DatabaseStore) (*WebServer, error) {
 	host := getStringOrDefault("SERVE_HOST", "localhost")
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
+	// metadata restrictions
+	metadata, err := newMetadataPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	// authentication
+	auth, err := newAuthPolicy()
+	if err != nil {
+		return nil, err
+	}
+	rbac, err := newRBACPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	// transport security
+	tlsPolicy, err := newTLSPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure TLS: %w", err)
+	}
+
 	// init logger
 	log, err := createLogger()
 	if err != nil {
@@ -119,6 +194,14 @@ func NewWebServer(store DatabaseStore) (*WebServer, error) {
 	}
 	log = log.With(zap.String("release", tagRelease))
 
+	// publish every config mutation for watchers
+	events := NewEventBus(getIntOrDefault("SERVE_EVENTS_HISTORY", defaultEventHistory))
+	eventStore, err := NewEventStore(store, events)
+	if err != nil {
+		return nil, err
+	}
+	cache, _ := store.(*CachedStore)
+
 	// init and return webserver struct
 	server := &WebServer{
 		log: log,
@@ -129,10 +212,36 @@ func NewWebServer(store DatabaseStore) (*WebServer, error) {
 			WriteTimeout:      genericWebServerTimeout,
 			IdleTimeout:       genericWebServerTimeout,
 			ErrorLog:          zap.NewStdLog(log),
+			ConnContext:       rememberConn,
 		},
-		store: store,
+		store:           eventStore,
+		metadata:        metadata,
+		extendsMaxDepth: getIntOrDefault("SERVE_EXTENDS_MAX_DEPTH", defaultExtendsMaxDepth),
+		renderEnv:       parseRenderEnv(),
+		backupDir:       backupDir(),
+		backupKeep:      getIntOrDefault("SERVE_BACKUP_KEEP", defaultBackupKeep),
+		events:          events,
+		cache:           cache,
+		webhooks:        newWebhookPolicy(),
+		auth:            auth,
+		rbac:            rbac,
+		auditLog:        getBoolOrDefault("SERVE_AUDIT_LOG", false),
+		stopping:        make(chan struct{}),
 	}
 
+	if tlsPolicy != nil {
+		server.TLSConfig = tlsPolicy.config(log)
+
+		// HTTP/2 enforces WriteTimeout per stream, which would cut watches and blocking queries short
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	// streaming requests end on shutdown instead of holding it up
+	var stopOnce sync.Once
+	server.RegisterOnShutdown(func() {
+		stopOnce.Do(func() { close(server.stopping) })
+	})
+
 	// register routes
 	server.initRoutes()
 
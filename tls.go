@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TLSPolicy holds certificate of the server and optional CA bundle client certificates are verified against,
+// files are read again once they change on disk so rotated certificates are picked up without restart
+type TLSPolicy struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	MinVersion   uint16
+	ClientAuth   tls.ClientAuthType
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	loaded    map[string]time.Time
+}
+
+const defaultTLSMinVersion = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+const (
+	clientAuthRequire  = "require"
+	clientAuthOptional = "optional"
+)
+
+// newTLSPolicy reads TLS settings from environment variables, nil is returned when TLS is not configured
+func newTLSPolicy() (*TLSPolicy, error) {
+	p := &TLSPolicy{
+		CertFile:     getStringOrDefault("SERVE_TLS_CERT", ""),
+		KeyFile:      getStringOrDefault("SERVE_TLS_KEY", ""),
+		ClientCAFile: getStringOrDefault("SERVE_TLS_CLIENT_CA", ""),
+		loaded:       map[string]time.Time{},
+	}
+
+	if p.CertFile == "" && p.KeyFile == "" {
+		if p.ClientCAFile != "" {
+			return nil, errors.New("client certificate verification requires SERVE_TLS_CERT and SERVE_TLS_KEY")
+		}
+		return nil, nil
+	}
+	if p.CertFile == "" || p.KeyFile == "" {
+		return nil, errors.New("both SERVE_TLS_CERT and SERVE_TLS_KEY are required")
+	}
+
+	version := getStringOrDefault("SERVE_TLS_MIN_VERSION", defaultTLSMinVersion)
+	minVersion, ok := tlsVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimum TLS version %q, one of 1.0, 1.1, 1.2, 1.3 is expected", version)
+	}
+	p.MinVersion = minVersion
+
+	if p.ClientCAFile != "" {
+		switch mode := getStringOrDefault("SERVE_TLS_CLIENT_AUTH", clientAuthRequire); mode {
+		case clientAuthRequire:
+			p.ClientAuth = tls.RequireAndVerifyClientCert
+		case clientAuthOptional:
+			p.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("unknown client certificate mode %q, require or optional is expected", mode)
+		}
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// files lists files policy is loaded from
+func (p *TLSPolicy) files() []string {
+	files := []string{p.CertFile, p.KeyFile}
+	if p.ClientCAFile != "" {
+		files = append(files, p.ClientCAFile)
+	}
+	return files
+}
+
+// reload reads certificate, key and CA bundle once any of them has been modified, previously loaded ones
+// are kept on error, e.g. while rotation has replaced certificate but not its key yet
+func (p *TLSPolicy) reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stamps := map[string]time.Time{}
+	changed := p.cert == nil
+	for _, file := range p.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		stamps[file] = info.ModTime()
+		if !info.ModTime().Equal(p.loaded[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if p.ClientCAFile != "" {
+		buf, err := os.ReadFile(p.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return errors.New("client CA bundle contains no PEM encoded certificates")
+		}
+	}
+
+	p.cert = &cert
+	p.clientCAs = pool
+	p.loaded = stamps
+	return nil
+}
+
+// config prepares server TLS configuration, every handshake checks for rotated files
+func (p *TLSPolicy) config(log *zap.Logger) *tls.Config {
+	return &tls.Config{
+		MinVersion: p.MinVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return p.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			if err := p.reload(); err != nil {
+				log.Error("Error reloading TLS certificate, previous one is kept", zap.Error(err))
+			}
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			return &tls.Config{
+				MinVersion:   p.MinVersion,
+				Certificates: []tls.Certificate{*p.cert},
+				ClientCAs:    p.clientCAs,
+				ClientAuth:   p.ClientAuth,
+				NextProtos:   []string{"http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// certPrincipal maps verified client certificate to principal named after common name of its subject,
+// whole subject is used for certificates without one
+func certPrincipal(r *http.Request) *Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	name := leaf.Subject.CommonName
+	if name == "" {
+		name = leaf.Subject.String()
+	}
+	return &Principal{Name: name, Kind: principalCert}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert creates certificate signed by parent, self-signed one when parent is nil
+func issueCert(t *testing.T, serial int64, cn string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// writeCert stores certificate and key as PEM files
+func writeCert(t *testing.T, c *testCert, certFile, keyFile string) {
+	t.Helper()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if keyFile == "" {
+		return
+	}
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+}
+
+// tlsClient prepares client trusting ca and presenting cert unless it is nil
+func tlsClient(ca *testCert, cert *testCert, maxVersion uint16) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	cfg := &tls.Config{RootCAs: pool, MaxVersion: maxVersion}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, 1, "ca", nil)
+	writeCert(t, issueCert(t, 2, "localhost", ca), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	writeCert(t, ca, filepath.Join(dir, "ca.crt"), "")
+
+	testCases := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{"disabled", map[string]string{}, ""},
+		{"key missing", map[string]string{"SERVE_TLS_CERT": filepath.Join(dir, "tls.crt")}, "both SERVE_TLS_CERT and SERVE_TLS_KEY"},
+		{"client CA only", map[string]string{"SERVE_TLS_CLIENT_CA": filepath.Join(dir, "ca.crt")}, "requires SERVE_TLS_CERT"},
+		{"unknown version", map[string]string{
+			"SERVE_TLS_CERT":        filepath.Join(dir, "tls.crt"),
+			"SERVE_TLS_KEY":         filepath.Join(dir, "tls.key"),
+			"SERVE_TLS_MIN_VERSION": "1.4",
+		}, "unknown minimum TLS version"},
+		{"unknown client auth", map[string]string{
+			"SERVE_TLS_CERT":        filepath.Join(dir, "tls.crt"),
+			"SERVE_TLS_KEY":         filepath.Join(dir, "tls.key"),
+			"SERVE_TLS_CLIENT_CA":   filepath.Join(dir, "ca.crt"),
+			"SERVE_TLS_CLIENT_AUTH": "sometimes",
+		}, "unknown client certificate mode"},
+		{"mismatched key", map[string]string{
+			"SERVE_TLS_CERT": filepath.Join(dir, "ca.crt"),
+			"SERVE_TLS_KEY":  filepath.Join(dir, "tls.key"),
+		}, "unable to load TLS certificate"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			p, err := newTLSPolicy()
+			if tc.err == "" {
+				if err != nil || p != nil {
+					t.Errorf("expected TLS to be disabled but got %v, %v", p, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q but got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := issueCert(t, 1, "ca", nil)
+	writeCert(t, ca, caFile, "")
+	writeCert(t, issueCert(t, 10, "localhost", ca), certFile, keyFile)
+	alice := issueCert(t, 20, "alice", ca)
+	mallory := issueCert(t, 30, "mallory", issueCert(t, 2, "rogue", nil))
+
+	srv, _ := newTestServer(t, map[string]string{
+		"SERVE_AUTH_ENABLED":    "true",
+		"SERVE_TLS_CERT":        certFile,
+		"SERVE_TLS_KEY":         keyFile,
+		"SERVE_TLS_CLIENT_CA":   caFile,
+		"SERVE_TLS_MIN_VERSION": "1.3",
+	})
+
+	if _, err := srv.store.InsertRoleBinding(&RoleBinding{Subject: "cert:alice", Role: roleReader, Pattern: "*"}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.TLS = srv.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	// client certificate maps to principal bound by its common name
+	res, err := tlsClient(ca, alice, 0).Get(ts.URL + "/configs")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	res.Body.Close()
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 10 {
+		t.Errorf("expected server certificate 10 but got %d", serial)
+	}
+
+	res, err = tlsClient(ca, alice, 0).Post(ts.URL+"/configs", "application/json", strings.NewReader(`{"name":"abc","metadata":{}}`))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	res.Body.Close()
+	assertResponseCode(t, res.StatusCode, http.StatusForbidden)
+
+	// handshakes below minimum version, without certificate or with untrusted one fail
+	for name, client := range map[string]*http.Client{
+		"tls 1.2":    tlsClient(ca, alice, tls.VersionTLS12),
+		"anonymous":  tlsClient(ca, nil, 0),
+		"untrusted":  tlsClient(ca, mallory, 0),
+		"unknown ca": tlsClient(mallory, alice, 0),
+	} {
+		if res, err := client.Get(ts.URL + "/configs"); err == nil {
+			res.Body.Close()
+			t.Errorf("%s: expected handshake to fail but got %d", name, res.StatusCode)
+		}
+	}
+
+	// rotated certificate is served without restart
+	writeCert(t, issueCert(t, 11, "localhost", ca), certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	res, err = tlsClient(ca, alice, 0).Get(ts.URL + "/configs")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	res.Body.Close()
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 11 {
+		t.Errorf("expected rotated server certificate 11 but got %d", serial)
+	}
+
+	// broken rotation keeps previous certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	res, err = tlsClient(ca, alice, 0).Get(ts.URL + "/configs")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	res.Body.Close()
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 11 {
+		t.Errorf("expected previous server certificate 11 to be kept but got %d", serial)
+	}
+}
+
+func TestTLSStreaming(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	ca := issueCert(t, 1, "ca", nil)
+	writeCert(t, issueCert(t, 10, "localhost", ca), certFile, keyFile)
+
+	srv, _ := newTestServer(t, map[string]string{
+		"SERVE_TLS_CERT": certFile,
+		"SERVE_TLS_KEY":  keyFile,
+	})
+	srv.WriteTimeout = 200 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	// client would take HTTP/2 if it was offered
+	client := tlsClient(ca, nil, 0)
+	client.Transport.(*http.Transport).ForceAttemptHTTP2 = true
+
+	url := "https://" + ln.Addr().String()
+	res, err := client.Get(url + "/watch")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer res.Body.Close()
+
+	assertResponseCode(t, res.StatusCode, http.StatusOK)
+	if res.ProtoMajor != 1 {
+		t.Errorf("expected HTTP/1.1 but got %s", res.Proto)
+	}
+
+	// watch outlives WriteTimeout
+	time.Sleep(3 * srv.WriteTimeout)
+	post, err := client.Post(url+"/configs", "application/json", strings.NewReader(`{"name":"abc","metadata":{}}`))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	post.Body.Close()
+	assertResponseCode(t, post.StatusCode, http.StatusOK)
+
+	events := readEvents(t, res, 1)
+	if len(events) != 1 || !strings.Contains(events[0], "event: created") {
+		t.Errorf("expected created event after WriteTimeout but got %v", events)
+	}
+}
@@ -28,6 +28,32 @@ func getIntOrDefault(name string, defValue int) int {
 	return i
 }
 
+// getBoolOrDefault allows to retrieve environment variable as boolean, fallback to defValue if not specified
+func getBoolOrDefault(name string, defValue bool) bool {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return defValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return defValue
+	}
+	return b
+}
+
+// getBoolOrFail gets environment variable as boolean, fallback to defValue if not specified, fail if it is not boolean
+func getBoolOrFail(name string, defValue bool) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return defValue, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %q is not boolean", name)
+	}
+	return b, nil
+}
+
 // getIntOrFail gets environment variable as integer, fail if not specified
 func getIntOrFail(name string) (int, error) {
 	v, ok := os.LookupEnv(name)
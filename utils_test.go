@@ -96,3 +96,39 @@ func TestGetEnvVarAsIntegerFail(t *testing.T) {
 		}
 	})
 }
+
+func TestGetEnvVarAsBooleanFail(t *testing.T) {
+
+	t.Run("defined valid", func(t *testing.T) {
+		os.Setenv("TEST_ENV_VAR", "true")
+		defer os.Unsetenv("TEST_ENV_VAR")
+
+		got, err := getBoolOrFail("TEST_ENV_VAR", false)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if !got {
+			t.Errorf("expected %t but got %t", true, got)
+		}
+	})
+
+	t.Run("defined invalid", func(t *testing.T) {
+		os.Setenv("TEST_ENV_VAR", "yes")
+		defer os.Unsetenv("TEST_ENV_VAR")
+
+		_, err := getBoolOrFail("TEST_ENV_VAR", true)
+		if err == nil {
+			t.Fatal("expected error, none thrown")
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		got, err := getBoolOrFail("TEST_ENV_VAR", true)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if !got {
+			t.Errorf("expected %t but got %t", true, got)
+		}
+	})
+}
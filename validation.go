@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// configNamePattern follows DNS label rules: lowercase alphanumerics and dashes, no leading or trailing dash
+var configNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+const maxConfigNameLength = 63
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Message string       `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// Error implements error interface
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(msgs, "; "))
+}
+
+// writeValidationError responds with 422 and detailed list of offending fields
+func writeValidationError(w http.ResponseWriter, verr *ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(verr)
+}
+
+// validateConfigName checks that name is safe to be used as URL path segment
+func validateConfigName(name string) *ValidationError {
+	var msg string
+	switch {
+	case name == "":
+		msg = "name is required"
+	case len(name) > maxConfigNameLength:
+		msg = fmt.Sprintf("name must be at most %d characters long", maxConfigNameLength)
+	case !configNamePattern.MatchString(name):
+		msg = "name must consist of lowercase alphanumeric characters or '-', and must start and end with an alphanumeric character"
+	default:
+		return nil
+	}
+	return &ValidationError{
+		Message: "invalid configuration item name",
+		Fields:  []FieldError{{Field: "name", Message: msg}},
+	}
+}
+
+// genericMetadata converts metadata into plain JSON values (maps, slices, strings, numbers...)
+func genericMetadata(md *Metadata) (interface{}, error) {
+	buf, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// joinPath appends object key to dotted field path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validateConfig runs config through server metadata policy and declared schema,
+// configs extending others are checked against schema with their effective metadata
+func (srv *WebServer) validateConfig(cfg *Config) (*ValidationError, error) {
+	return srv.validateConfigWith(srv.store, cfg)
+}
+
+// validateConfigWith is validateConfig reading parents and schemas through reader, e.g. a transaction
+func (srv *WebServer) validateConfigWith(reader configReader, cfg *Config) (*ValidationError, error) {
+	if verr := validateLabels(cfg.Labels); verr != nil {
+		return verr, nil
+	}
+
+	verr, err := srv.metadata.check(cfg.Metadata)
+	if err != nil || verr != nil {
+		return verr, err
+	}
+
+	if len(cfg.Extends) == 0 {
+		return srv.validateConfigSchema(reader, cfg)
+	}
+
+	resolved, err := srv.newStoreResolver(reader, nil).resolvedConfig(cfg)
+	if err != nil {
+		var rerr *ValidationError
+		if errors.As(err, &rerr) {
+			return rerr, nil
+		}
+		return nil, err
+	}
+
+	return srv.validateConfigSchema(reader, resolved)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	watchHeartbeatInterval = 15 * time.Second
+
+	eventReset = "reset"
+	eventLost  = "lost"
+)
+
+type connContextKey struct{}
+
+// rememberConn keeps connection in request context, streaming handlers lift its write deadline
+func rememberConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// clearWriteDeadline lets long-lived response outlive server WriteTimeout
+func clearWriteDeadline(r *http.Request) {
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		c.SetWriteDeadline(time.Time{})
+	}
+}
+
+// extendWriteDeadline gives response which waits for changes more time than server WriteTimeout allows
+func extendWriteDeadline(r *http.Request, d time.Duration) {
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		c.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// parseLastEventID reads sequence number client has seen from Last-Event-ID header or since parameter
+func parseLastEventID(r *http.Request) (uint64, bool, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("since")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+
+	seq, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid event id %q", v)
+	}
+	return seq, true, nil
+}
+
+// writeSSE writes single server-sent event
+func writeSSE(w http.ResponseWriter, id uint64, event string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf)
+	return err
+}
+
+// streamEvents streams events matching match as server-sent events until client goes away or server stops,
+// events the bus does not keep anymore are replayed from the change log, clients get reset event and should
+// reload their state only when it cannot be read
+func (srv *WebServer) streamEvents(w http.ResponseWriter, r *http.Request, match func(*ConfigEvent) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	since, resume, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !resume {
+		since = srv.events.Seq()
+	}
+
+	// events of configs caller cannot read are never sent
+	readable, reveal, filter := srv.readable(r), srv.revealable(r), match
+	match = func(event *ConfigEvent) bool {
+		return readable(event.Name) && filter(event)
+	}
+
+	sub, replay, complete := srv.events.Subscribe(since, match)
+	defer sub.Close()
+
+	clearWriteDeadline(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// events replayed from the change log may arrive from the bus as well
+	last := since
+	send := func(event ConfigEvent) error {
+		if event.Seq <= last {
+			return nil
+		}
+		last = event.Seq
+		return writeSSE(w, event.Seq, event.Type, redactEvent(event, reveal))
+	}
+
+	if complete {
+		for _, event := range replay {
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	} else if err := srv.replayChanges(since, match, send); err != nil {
+		srv.log.Info("Error replaying changes", zap.Error(err))
+		if err := writeSSE(w, 0, eventReset, map[string]uint64{"seq": srv.events.Seq()}); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-srv.stopping:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				// subscriber fell behind, client resumes from the last event it got
+				writeSSE(w, 0, eventLost, map[string]uint64{"seq": srv.events.Seq()})
+				flusher.Flush()
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// replayChanges passes changes recorded after since which match to fn, oldest first, reading the change log
+// page by page, so subscribers resume even from events the bus does not keep anymore
+func (srv *WebServer) replayChanges(since uint64, match func(*ConfigEvent) bool, fn func(ConfigEvent) error) error {
+	for {
+		changes, err := srv.store.GetChanges(since, maxChangesLimit)
+		if err != nil {
+			return err
+		}
+		for _, change := range *changes {
+			since = change.Seq
+			if match != nil && !match(&change) {
+				continue
+			}
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+		if len(*changes) < maxChangesLimit {
+			return nil
+		}
+	}
+}
+
+// parseWatchFilter builds event matcher from label selector and search filters, both have to match
+func parseWatchFilter(r *http.Request) (func(*ConfigEvent) bool, error) {
+	selector, err := parseLabelSelector(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := parseSearchFilters(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return func(event *ConfigEvent) bool {
+		return event.Config != nil && selector.matches(event.Config.Labels) && matchMetadata(event.Config.Metadata, filters)
+	}, nil
+}
+
+// configsWatchHandler handles GET /configs/abc/watch
+func (srv *WebServer) configsWatchHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	srv.streamEvents(w, r, func(event *ConfigEvent) bool {
+		return event.Name == name
+	})
+}
+
+// watchGetHandler handles GET /watch
+func (srv *WebServer) watchGetHandler(w http.ResponseWriter, r *http.Request) {
+	match, err := parseWatchFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	srv.streamEvents(w, r, match)
+}
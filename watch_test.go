@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents reads n server-sent events from stream, returning their event and id lines
+func readEvents(t *testing.T, res *http.Response, n int) []string {
+	t.Helper()
+
+	got := make(chan []string, 1)
+	go func() {
+		var events []string
+		var current []string
+		scanner := bufio.NewScanner(res.Body)
+		for len(events) < n && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if len(current) > 0 {
+					events = append(events, strings.Join(current, " "))
+				}
+				current = nil
+			case strings.HasPrefix(line, "id:"), strings.HasPrefix(line, "event:"):
+				current = append(current, line)
+			}
+		}
+		got <- events
+	}()
+
+	select {
+	case events := <-got:
+		return events
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for events")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil)
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		res, err := http.Get(ts.URL + "/watch?metadata.env=prod")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer res.Body.Close()
+
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		for _, body := range []string{
+			`{"name":"dev-one","metadata":{"env":"dev"}}`,
+			`{"name":"prod-one","metadata":{"env":"prod"}}`,
+		} {
+			post, err := http.Post(ts.URL+"/configs", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			post.Body.Close()
+		}
+
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/configs/prod-one", nil)
+		del, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		del.Body.Close()
+
+		events := readEvents(t, res, 2)
+		want := []string{"id: 2 event: created", "id: 3 event: deleted"}
+		if strings.Join(events, ",") != strings.Join(want, ",") {
+			t.Errorf("expected events %v but got %v", want, events)
+		}
+
+		// resume single config stream from the beginning
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+"/configs/dev-one/watch", nil)
+		req.Header.Set("Last-Event-ID", "0")
+		resumed, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer resumed.Body.Close()
+
+		events = readEvents(t, resumed, 1)
+		if len(events) != 1 || events[0] != "id: 1 event: created" {
+			t.Errorf("unexpected resumed events %v", events)
+		}
+
+		bad, err := http.Get(ts.URL + "/watch?labelSelector=team%3D%3D%3D")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		bad.Body.Close()
+		assertResponseCode(t, bad.StatusCode, http.StatusBadRequest)
+	})
+
+	t.Run("label selector", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil)
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		res, err := http.Get(ts.URL + "/watch?labelSelector=team%3Dsre,!legacy")
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer res.Body.Close()
+
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		for _, body := range []string{
+			`{"name":"app-one","labels":{"team":"app"},"metadata":{}}`,
+			`{"name":"sre-old","labels":{"team":"sre","legacy":""},"metadata":{}}`,
+			`{"name":"sre-one","labels":{"team":"sre"},"metadata":{}}`,
+		} {
+			post := getResponse(t, srv, http.MethodPost, "/configs", strings.NewReader(body))
+			assertResponseCode(t, post.StatusCode, http.StatusOK)
+		}
+
+		// labels are kept by updates which do not replace them
+		patch := getResponse(t, srv, http.MethodPatch, "/configs/sre-one", strings.NewReader(`{"metadata":{"v":"2"}}`))
+		assertResponseCode(t, patch.StatusCode, http.StatusOK)
+
+		events := readEvents(t, res, 2)
+		want := []string{"id: 3 event: created", "id: 4 event: updated"}
+		if strings.Join(events, ",") != strings.Join(want, ",") {
+			t.Errorf("expected events %v but got %v", want, events)
+		}
+	})
+
+	t.Run("resume after restart", func(t *testing.T) {
+
+		// changes recorded before server has started are in the change log only
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			for _, name := range []string{"one", "two", "three"} {
+				if _, err := db.InsertConfig(&Config{Name: name, Metadata: &Metadata{}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/watch", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer res.Body.Close()
+
+		post, err := http.Post(ts.URL+"/configs", "application/json", strings.NewReader(`{"name":"four","metadata":{}}`))
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		post.Body.Close()
+
+		events := readEvents(t, res, 3)
+		want := []string{"id: 2 event: created", "id: 3 event: created", "id: 4 event: created"}
+		if strings.Join(events, ",") != strings.Join(want, ",") {
+			t.Errorf("expected events %v but got %v", want, events)
+		}
+	})
+
+	t.Run("resume gap", func(t *testing.T) {
+		bus := NewEventBus(2)
+		for i := 0; i < 4; i++ {
+			bus.Publish(ConfigEvent{Type: eventUpdated, Name: "abc"})
+		}
+
+		sub, replay, complete := bus.Subscribe(1, nil)
+		defer sub.Close()
+
+		if complete || len(replay) != 2 || replay[0].Seq != 3 {
+			t.Errorf("unexpected replay %v complete %v", replay, complete)
+		}
+	})
+
+}
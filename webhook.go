@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type Webhook struct {
+	ID      int         `db:"id" json:"id"`
+	URL     string      `db:"url" json:"url"`
+	Secret  string      `db:"secret" json:"-"`
+	Names   ConfigNames `db:"names" json:"names,omitempty"`
+	Filters StringMap   `db:"filters" json:"filters,omitempty"`
+	Events  ConfigNames `db:"events" json:"events,omitempty"`
+	Created time.Time   `db:"created_at" json:"created_at"`
+
+	// labels of changed config have to match, e.g. team=sre,!legacy
+	LabelSelector string `db:"label_selector" json:"label_selector,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID          int        `db:"id" json:"id"`
+	WebhookID   int        `db:"webhook_id" json:"webhook_id"`
+	EventSeq    uint64     `db:"event_seq" json:"event_seq"`
+	EventType   string     `db:"event_type" json:"event_type"`
+	Name        string     `db:"config_name" json:"name"`
+	Payload     string     `db:"payload" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"attempts"`
+	NextAttempt time.Time  `db:"next_attempt_at" json:"next_attempt_at"`
+	StatusCode  int        `db:"last_status_code" json:"last_status_code,omitempty"`
+	Error       string     `db:"last_error" json:"last_error,omitempty"`
+	Created     time.Time  `db:"created_at" json:"created_at"`
+	DeliveredAt *time.Time `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// WebhookPolicy controls how deliveries are sent and retried
+type WebhookPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Client      *http.Client
+}
+
+type StringMap map[string]string
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const (
+	defaultWebhookMaxAttempts = 8
+	defaultWebhookBackoff     = 5
+	defaultWebhookInterval    = time.Second
+	webhookMaxBackoff         = time.Hour
+	webhookTimeout            = 10 * time.Second
+	webhookBatchSize          = 100
+	webhookParallelism        = 4
+)
+
+const (
+	webhookEventHeader     = "X-Fresh-Event"
+	webhookDeliveryHeader  = "X-Fresh-Delivery"
+	webhookSignatureHeader = "X-Fresh-Signature"
+)
+
+// webhookCursor names position in the change log deliveries have been queued up to
+const webhookCursor = "webhooks"
+
+const webhookColumns = `id, url, secret, names, filters, events, label_selector, created_at`
+
+const deliveryColumns = `id, webhook_id, event_seq, event_type, config_name, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+// newWebhookPolicy reads delivery settings from environment variables
+func newWebhookPolicy() *WebhookPolicy {
+	return &WebhookPolicy{
+		MaxAttempts: getIntOrDefault("SERVE_WEBHOOK_MAX_ATTEMPTS", defaultWebhookMaxAttempts),
+		Backoff:     time.Duration(getIntOrDefault("SERVE_WEBHOOK_BACKOFF", defaultWebhookBackoff)) * time.Second,
+		MaxBackoff:  webhookMaxBackoff,
+		Client:      &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// backoff returns delay before next attempt, doubling with every failed one
+func (p *WebhookPolicy) backoff(attempts int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempts && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Scan performs custom-type conversion, string map is stored as JSON object
+func (m *StringMap) Scan(src interface{}) error {
+	switch t := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(t, m)
+	case string:
+		return json.Unmarshal([]byte(t), m)
+	default:
+		return fmt.Errorf("unexpected data type %t", t)
+	}
+}
+
+// Value performs custom-type conversion, serialize string map as JSON object
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	buf, err := json.Marshal(map[string]string(m))
+	return string(buf), err
+}
+
+// InsertWebhook stores webhook registration
+func (db *Database) InsertWebhook(hook *Webhook) (int, error) {
+	stmt := `INSERT INTO webhooks (url, secret, names, filters, events, label_selector, created_at) VALUES (?, ?, ?, ?, ?, ?, datetime('now'))`
+
+	result, err := db.execTx(stmt, hook.URL, hook.Secret, hook.Names, hook.Filters, hook.Events, hook.LabelSelector)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetWebhooks retrieves every webhook
+func (db *Database) GetWebhooks() (*[]Webhook, error) {
+	hooks := []Webhook{}
+	if err := db.Select(&hooks, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id ASC`); err != nil {
+		return nil, err
+	}
+	return &hooks, nil
+}
+
+// GetWebhook retrieves webhook by its id
+func (db *Database) GetWebhook(id int) (*Webhook, error) {
+	hook := &Webhook{}
+	if err := db.Get(hook, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// DeleteWebhook removes webhook along with its deliveries, sql.ErrNoRows is returned if there is nothing to remove
+func (db *Database) DeleteWebhook(id int) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// EnqueueDeliveries stores deliveries to be sent together with seq of the change they were queued up to
+func (db *Database) EnqueueDeliveries(seq uint64, dls []WebhookDelivery) error {
+	return db.inTx(func(tx *sqlx.Tx) error {
+		if err := advanceChangeCursor(tx, webhookCursor, seq); err != nil {
+			return err
+		}
+
+		stmt := `
+		INSERT INTO webhook_deliveries (webhook_id, event_seq, event_type, config_name, payload, status, attempts, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, 0, ?, datetime('now'))`
+		for _, dl := range dls {
+			if _, err := tx.Exec(stmt, dl.WebhookID, dl.EventSeq, dl.EventType, dl.Name, dl.Payload, deliveryPending, dl.NextAttempt.UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetDueDeliveries retrieves up to limit pending deliveries due at now, oldest first
+func (db *Database) GetDueDeliveries(now time.Time, limit int) (*[]WebhookDelivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at ASC, id ASC LIMIT ?`
+
+	dls := []WebhookDelivery{}
+	if err := db.Select(&dls, stmt, deliveryPending, now.UTC(), limit); err != nil {
+		return nil, err
+	}
+	return &dls, nil
+}
+
+// UpdateDelivery records outcome of delivery attempt
+func (db *Database) UpdateDelivery(dl *WebhookDelivery) error {
+	stmt := `
+	UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?, last_error = ?, delivered_at = ?
+		WHERE id = ?`
+
+	var delivered interface{}
+	if dl.DeliveredAt != nil {
+		delivered = dl.DeliveredAt.UTC()
+	}
+
+	_, err := db.writer.Exec(stmt, dl.Status, dl.Attempts, dl.NextAttempt.UTC(), dl.StatusCode, dl.Error, delivered, dl.ID)
+	return err
+}
+
+// GetDeliveries retrieves deliveries of webhook, of every webhook if id is zero, optionally only with given status
+func (db *Database) GetDeliveries(webhookID int, status string) (*[]WebhookDelivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE 1 = 1`
+
+	args := []interface{}{}
+	if webhookID != 0 {
+		stmt += ` AND webhook_id = ?`
+		args = append(args, webhookID)
+	}
+	if status != "" {
+		stmt += ` AND status = ?`
+		args = append(args, status)
+	}
+	stmt += ` ORDER BY id ASC`
+
+	dls := []WebhookDelivery{}
+	if err := db.Select(&dls, stmt, args...); err != nil {
+		return nil, err
+	}
+	return &dls, nil
+}
+
+// RetryDelivery puts dead delivery back to the queue, sql.ErrNoRows is returned if there is no such dead delivery
+func (db *Database) RetryDelivery(id int, now time.Time) error {
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`
+
+	_, err := db.execTx(stmt, deliveryPending, now.UTC(), id, deliveryDead)
+	return err
+}
+
+// signWebhook computes hex encoded HMAC-SHA256 of timestamp and payload joined with dot
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchWebhook reports whether event passes webhook event type, name, label and metadata filters
+func matchWebhook(hook *Webhook, event *ConfigEvent) bool {
+	if len(hook.Events) > 0 && !containsString(hook.Events, event.Type) {
+		return false
+	}
+	if len(hook.Names) > 0 && !containsString(hook.Names, event.Name) {
+		return false
+	}
+	if hook.LabelSelector != "" {
+		selector, err := parseLabelSelector(hook.LabelSelector)
+		if err != nil || event.Config == nil || !selector.matches(event.Config.Labels) {
+			return false
+		}
+	}
+	if len(hook.Filters) > 0 {
+		query := url.Values{}
+		for k, v := range hook.Filters {
+			query.Set(k, v)
+		}
+		filters, err := parseSearchFilters(query)
+		if err != nil || event.Config == nil {
+			return false
+		}
+		return matchMetadata(event.Config.Metadata, filters)
+	}
+	return true
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runWebhooks queues deliveries for published events and sends due ones, until ctx is done
+func (srv *WebServer) runWebhooks(ctx context.Context, interval time.Duration) error {
+	wake := make(chan struct{}, 1)
+
+	go srv.queueWebhooks(ctx, wake, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-wake:
+		}
+		srv.deliverWebhooks(time.Now())
+	}
+}
+
+// queueWebhooks turns events into deliveries of matching webhooks, wake is signalled when some were queued,
+// it resumes from the stored position so that changes made while server was down are delivered too,
+// events which failed to be queued are retried from the change log every interval
+func (srv *WebServer) queueWebhooks(ctx context.Context, wake chan<- struct{}, interval time.Duration) {
+	sub, _, _ := srv.events.Subscribe(srv.events.Seq(), nil)
+
+	retry := time.NewTicker(interval)
+	defer retry.Stop()
+
+	last, err := srv.store.GetChangeCursor(webhookCursor)
+	for err != nil && !errors.Is(err, sql.ErrNoRows) {
+		srv.log.Info("Error retrieving webhook position", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			sub.Close()
+			return
+		case <-retry.C:
+		}
+		last, err = srv.store.GetChangeCursor(webhookCursor)
+	}
+	if err != nil {
+		// nothing has been queued yet, webhooks get changes made from now on
+		last = srv.events.Seq()
+	}
+	last = srv.queueWebhookChanges(last)
+
+	for {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+			return
+		case <-retry.C:
+			if last >= srv.events.Seq() {
+				continue
+			}
+			last = srv.queueWebhookChanges(last)
+		case event, ok := <-sub.C:
+			if !ok {
+				// fell behind, catch up from the change log, live events queued that way are skipped
+				sub, _, _ = srv.events.Subscribe(srv.events.Seq(), nil)
+				last = srv.queueWebhookChanges(last)
+				continue
+			}
+			if event.Seq <= last {
+				continue
+			}
+			if event.Seq > last+1 {
+				// some earlier event has not been queued yet
+				last = srv.queueWebhookChanges(last)
+				continue
+			}
+			if err := srv.queueWebhookEvent(&event); err != nil {
+				srv.log.Info("Error queueing webhook deliveries", zap.Uint64("seq", event.Seq), zap.Error(err))
+				continue
+			}
+			last = event.Seq
+		}
+
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queueWebhookChanges queues deliveries for every change recorded after since, returns the last queued one
+func (srv *WebServer) queueWebhookChanges(since uint64) uint64 {
+	for {
+		changes, err := srv.store.GetChanges(since, maxChangesLimit)
+		if err != nil {
+			srv.log.Info("Error retrieving changes for webhooks", zap.Uint64("since", since), zap.Error(err))
+			return since
+		}
+		for idx := range *changes {
+			if err := srv.queueWebhookEvent(&(*changes)[idx]); err != nil {
+				srv.log.Info("Error queueing webhook deliveries", zap.Uint64("seq", (*changes)[idx].Seq), zap.Error(err))
+				return since
+			}
+			since = (*changes)[idx].Seq
+		}
+		if len(*changes) < maxChangesLimit {
+			return since
+		}
+	}
+}
+
+// queueWebhookEvent stores delivery of event for every matching webhook and moves stored position past it
+func (srv *WebServer) queueWebhookEvent(event *ConfigEvent) error {
+	hooks, err := srv.store.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	// receivers never get secret values
+	payload, err := json.Marshal(redactEvent(*event, nil))
+	if err != nil {
+		return err
+	}
+
+	var dls []WebhookDelivery
+	for idx := range *hooks {
+		hook := &(*hooks)[idx]
+		if !matchWebhook(hook, event) {
+			continue
+		}
+		dls = append(dls, WebhookDelivery{
+			WebhookID:   hook.ID,
+			EventSeq:    event.Seq,
+			EventType:   event.Type,
+			Name:        event.Name,
+			Payload:     string(payload),
+			NextAttempt: time.Now(),
+		})
+	}
+
+	return srv.store.EnqueueDeliveries(event.Seq, dls)
+}
+
+// deliverWebhooks sends deliveries due at now and records their outcome
+func (srv *WebServer) deliverWebhooks(now time.Time) {
+	dls, err := srv.store.GetDueDeliveries(now, webhookBatchSize)
+	if err != nil {
+		srv.log.Info("Error retrieving webhook deliveries", zap.Error(err))
+		return
+	}
+
+	// webhooks are delivered to concurrently, each one receiving up to webhookParallelism requests at once
+	var wg sync.WaitGroup
+	hooks := map[int]*Webhook{}
+	slots := map[int]chan struct{}{}
+	for idx := range *dls {
+		dl := &(*dls)[idx]
+
+		hook, ok := hooks[dl.WebhookID]
+		if !ok {
+			if hook, err = srv.store.GetWebhook(dl.WebhookID); err != nil {
+				srv.log.Info("Error retrieving webhook", zap.Int("id", dl.WebhookID), zap.Error(err))
+				continue
+			}
+			hooks[dl.WebhookID] = hook
+			slots[dl.WebhookID] = make(chan struct{}, webhookParallelism)
+		}
+
+		slot := slots[dl.WebhookID]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			slot <- struct{}{}
+			defer func() { <-slot }()
+
+			srv.sendWebhook(hook, dl, now)
+
+			if err := srv.store.UpdateDelivery(dl); err != nil {
+				srv.log.Info("Error recording webhook delivery", zap.Int("id", dl.ID), zap.Error(err))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sendWebhook makes single delivery attempt, failed ones are rescheduled with backoff until they are dead
+func (srv *WebServer) sendWebhook(hook *Webhook, dl *WebhookDelivery, now time.Time) {
+	dl.Attempts++
+	dl.StatusCode = 0
+	dl.Error = ""
+
+	err := func() error {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+
+		req, err := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(dl.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "fresh-server/"+tagRelease)
+		req.Header.Set(webhookEventHeader, dl.EventType)
+		req.Header.Set(webhookDeliveryHeader, strconv.Itoa(dl.ID))
+		req.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%s,v1=%s", timestamp, signWebhook(hook.Secret, timestamp, []byte(dl.Payload))))
+
+		res, err := srv.webhooks.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+		dl.StatusCode = res.StatusCode
+		if res.StatusCode < 200 || res.StatusCode > 299 {
+			return fmt.Errorf("receiver responded with %s", res.Status)
+		}
+		return nil
+	}()
+
+	if err == nil {
+		dl.Status = deliveryDelivered
+		dl.DeliveredAt = &now
+		return
+	}
+
+	dl.Error = err.Error()
+	if dl.Attempts >= srv.webhooks.MaxAttempts {
+		dl.Status = deliveryDead
+		srv.log.Info("Webhook delivery is dead", zap.Int("id", dl.ID), zap.Int("webhook", dl.WebhookID), zap.Error(err))
+		return
+	}
+	dl.NextAttempt = now.Add(srv.webhooks.backoff(dl.Attempts))
+}
+
+// validateWebhook checks webhook registration
+func validateWebhook(hook *Webhook) *ValidationError {
+	verr := &ValidationError{Message: "webhook is invalid"}
+
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.Fields = append(verr.Fields, FieldError{Field: "url", Message: "must be absolute http or https URL"})
+	}
+	if hook.Secret == "" {
+		verr.Fields = append(verr.Fields, FieldError{Field: "secret", Message: "is required"})
+	}
+	for _, typ := range hook.Events {
+		if !containsString(eventTypes, typ) {
+			verr.Fields = append(verr.Fields, FieldError{Field: "events", Message: fmt.Sprintf("unknown event type %q", typ)})
+		}
+	}
+	if _, err := parseLabelSelector(hook.LabelSelector); err != nil {
+		verr.Fields = append(verr.Fields, FieldError{Field: "label_selector", Message: err.Error()})
+	}
+	for k := range hook.Filters {
+		if !strings.HasPrefix(k, searchMetadataPrefix) || len(k) == len(searchMetadataPrefix) {
+			verr.Fields = append(verr.Fields, FieldError{Field: joinPath("filters", k), Message: "must be metadata.key search filter"})
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// webhooksPostHandler handles POST /webhooks
+func (srv *WebServer) webhooksPostHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Webhook
+		Secret string `json:"secret"`
+	}
+	srv.metadata.limitBody(w, r, 1)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	hook := req.Webhook
+	hook.Secret = req.Secret
+
+	if verr := validateWebhook(&hook); verr != nil {
+		writeValidationError(w, verr)
+		return
+	}
+
+	id, err := srv.storeFor(r).InsertWebhook(&hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hook.ID = id
+	hook.Created = time.Now().UTC()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// webhooksGetAllHandler handles GET /webhooks
+func (srv *WebServer) webhooksGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := srv.store.GetWebhooks()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(hooks); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// webhooksGetOneHandler handles GET /webhooks/123
+func (srv *WebServer) webhooksGetOneHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	hook, err := srv.store.GetWebhook(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// webhooksDeleteOneHandler handles DELETE /webhooks/123
+func (srv *WebServer) webhooksDeleteOneHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if err := srv.storeFor(r).DeleteWebhook(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "webhook was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "webhook has successfully been erased")
+}
+
+// webhooksDeliveriesHandler handles GET /webhooks/123/deliveries and GET /webhooks/deliveries, dead ones
+// form the dead-letter list
+func (srv *WebServer) webhooksDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	dls, err := srv.store.GetDeliveries(id, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(dls); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// webhooksRetryHandler handles POST /webhooks/deliveries/123/retry
+func (srv *WebServer) webhooksRetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if err := srv.storeFor(r).RetryDelivery(id, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "dead webhook delivery was not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, "webhook delivery has successfully been queued")
+}
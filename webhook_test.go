@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newWebhookServer prepares server retrying deliveries quickly
+func newWebhookServer(t *testing.T) *WebServer {
+	t.Helper()
+
+	srv, _ := newTestServer(t, nil)
+	srv.webhooks.Backoff = time.Millisecond
+	srv.webhooks.MaxAttempts = 3
+	return srv
+}
+
+// waitForDeliveries polls deliveries of webhook until n of them have status
+func waitForDeliveries(t *testing.T, srv *WebServer, id int, status string, n int) []WebhookDelivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		dls, err := srv.store.GetDeliveries(id, status)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*dls) >= n {
+			return *dls
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d %s deliveries", n, status)
+	return nil
+}
+
+func TestWebhooks(t *testing.T) {
+
+	t.Run("signed delivery", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		received := make(chan *http.Request, 4)
+		bodies := make(chan []byte, 4)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+		}))
+		defer receiver.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go srv.runWebhooks(ctx, 10*time.Millisecond)
+
+		hook := `{"url":"` + receiver.URL + `","secret":"s3cret","events":["created"],"filters":{"metadata.env":"prod"}}`
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(hook))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		var created Webhook
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		// wait until dispatcher is subscribed before changing configs
+		time.Sleep(50 * time.Millisecond)
+
+		for _, body := range []string{
+			`{"name":"dev-one","metadata":{"env":"dev"}}`,
+			`{"name":"prod-one","metadata":{"env":"prod"}}`,
+		} {
+			res := getResponse(t, srv, "POST", "/configs", strings.NewReader(body))
+			assertResponseCode(t, res.StatusCode, http.StatusOK)
+		}
+
+		var r *http.Request
+		var body []byte
+		select {
+		case r = <-received:
+			body = <-bodies
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+
+		if got := r.Header.Get(webhookEventHeader); got != eventCreated {
+			t.Errorf("got event header %q, want %q", got, eventCreated)
+		}
+
+		var timestamp, signature string
+		for _, part := range strings.Split(r.Header.Get(webhookSignatureHeader), ",") {
+			if v := strings.TrimPrefix(part, "t="); v != part {
+				timestamp = v
+			}
+			if v := strings.TrimPrefix(part, "v1="); v != part {
+				signature = v
+			}
+		}
+		if want := signWebhook("s3cret", timestamp, body); signature != want {
+			t.Errorf("got signature %q, want %q", signature, want)
+		}
+
+		var event ConfigEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if event.Name != "prod-one" {
+			t.Errorf("got event for %q, want %q", event.Name, "prod-one")
+		}
+
+		dls := waitForDeliveries(t, srv, created.ID, deliveryDelivered, 1)
+		if len(dls) != 1 || dls[0].Attempts != 1 {
+			t.Errorf("got deliveries %+v, want single delivered one", dls)
+		}
+	})
+
+	t.Run("dead letter and retry", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		fail := make(chan bool, 1)
+		fail <- true
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			failing := <-fail
+			fail <- failing
+			if failing {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			}
+		}))
+		defer receiver.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go srv.runWebhooks(ctx, 10*time.Millisecond)
+
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"`+receiver.URL+`","secret":"s3cret"}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		time.Sleep(50 * time.Millisecond)
+
+		res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"one","metadata":{"a":1}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		dls := waitForDeliveries(t, srv, 0, deliveryDead, 1)
+		if dls[0].Attempts != 3 || dls[0].StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got dead delivery %+v, want 3 attempts with 503", dls[0])
+		}
+
+		<-fail
+		fail <- false
+
+		res = getResponse(t, srv, "POST", "/webhooks/deliveries/"+strconv.Itoa(dls[0].ID)+"/retry", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		waitForDeliveries(t, srv, 0, deliveryDelivered, 1)
+
+		res = getResponse(t, srv, "POST", "/webhooks/deliveries/"+strconv.Itoa(dls[0].ID)+"/retry", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusNotFound)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		for _, body := range []string{
+			`{"url":"ftp://example.com","secret":"x"}`,
+			`{"url":"http://example.com"}`,
+			`{"url":"http://example.com","secret":"x","events":["renamed"]}`,
+			`{"url":"http://example.com","secret":"x","filters":{"env":"prod"}}`,
+			`{"url":"http://example.com","secret":"x","label_selector":"team=a b"}`,
+		} {
+			res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(body))
+			assertResponseCode(t, res.StatusCode, http.StatusUnprocessableEntity)
+		}
+
+		// overlay changes may be subscribed to as well
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"http://example.com","secret":"x","events":["overlay_updated","overlay_deleted"]}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+	})
+
+	t.Run("label selector", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"http://example.com","secret":"x","label_selector":"team=sre,!legacy"}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		hooks, err := srv.store.GetWebhooks()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if len(*hooks) != 1 || (*hooks)[0].LabelSelector != "team=sre,!legacy" {
+			t.Fatalf("expected label selector to be stored but got %+v", *hooks)
+		}
+
+		for labels, want := range map[string]bool{
+			`{"team":"sre"}`:              true,
+			`{"team":"app"}`:              false,
+			`{"team":"sre","legacy":"1"}`: false,
+			`{}`:                          false,
+		} {
+			cfg := &Config{Name: "abc"}
+			if err := json.Unmarshal([]byte(labels), &cfg.Labels); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if got := matchWebhook(&(*hooks)[0], &ConfigEvent{Type: eventUpdated, Name: "abc", Config: cfg}); got != want {
+				t.Errorf("%s: expected match %v but got %v", labels, want, got)
+			}
+		}
+	})
+
+	t.Run("oversized body", func(t *testing.T) {
+		srv, _ := newTestServer(t, map[string]string{"SERVE_METADATA_MAX_SIZE": "1024"})
+
+		huge := `{"url":"http://example.com/` + strings.Repeat("x", 8*1024) + `","secret":"x"}`
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(huge))
+		assertResponseCode(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("secret is not exposed", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"http://example.com","secret":"s3cret"}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		res = getResponse(t, srv, "GET", "/webhooks", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		body, _ := io.ReadAll(res.Body)
+		if strings.Contains(string(body), "s3cret") {
+			t.Errorf("secret leaked in %s", body)
+		}
+
+		res = getResponse(t, srv, "DELETE", "/webhooks/1", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		res = getResponse(t, srv, "GET", "/webhooks/1", nil)
+		assertResponseCode(t, res.StatusCode, http.StatusNotFound)
+	})
+}
+
+// failingQueueStore fails to queue deliveries while failing is set
+type failingQueueStore struct {
+	DatabaseStore
+	failing int32
+}
+
+func (s *failingQueueStore) EnqueueDeliveries(seq uint64, dls []WebhookDelivery) error {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return errors.New("database is locked")
+	}
+	return s.DatabaseStore.EnqueueDeliveries(seq, dls)
+}
+
+func TestWebhookQueue(t *testing.T) {
+
+	t.Run("resume after restart", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		names := make(chan string, 4)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event ConfigEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			names <- event.Name
+		}))
+		defer receiver.Close()
+
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"`+receiver.URL+`","secret":"s3cret"}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go srv.runWebhooks(ctx, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+
+		res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"one","metadata":{}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		waitForDeliveries(t, srv, 0, deliveryDelivered, 1)
+
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+
+		// changes made while webhooks are not running are queued once they start again
+		res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"two","metadata":{}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		if seq, err := srv.store.GetChangeCursor(webhookCursor); err != nil || seq != 1 {
+			t.Fatalf("expected webhooks to be queued up to 1 but got %d: %v", seq, err)
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		go srv.runWebhooks(ctx, 10*time.Millisecond)
+
+		dls := waitForDeliveries(t, srv, 0, deliveryDelivered, 2)
+		if len(dls) != 2 {
+			t.Errorf("expected every change to be delivered once but got %+v", dls)
+		}
+		for _, want := range []string{"one", "two"} {
+			if got := <-names; got != want {
+				t.Errorf("got delivery of %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("failure is retried", func(t *testing.T) {
+		srv := newWebhookServer(t)
+		store := &failingQueueStore{DatabaseStore: srv.store, failing: 1}
+		srv.store = store
+
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer receiver.Close()
+
+		res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"`+receiver.URL+`","secret":"s3cret"}`))
+		assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go srv.runWebhooks(ctx, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+
+		res = getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"one","metadata":{}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+		time.Sleep(50 * time.Millisecond)
+
+		if _, err := srv.store.GetChangeCursor(webhookCursor); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected position not to move while queueing fails but got %v", err)
+		}
+
+		atomic.StoreInt32(&store.failing, 0)
+		waitForDeliveries(t, srv, 0, deliveryDelivered, 1)
+	})
+
+	t.Run("bounded parallelism", func(t *testing.T) {
+		srv := newWebhookServer(t)
+
+		// requests are held until both webhooks have as many of them in flight as they may
+		var mu sync.Mutex
+		inflight, peak := map[string]int{}, map[string]int{}
+		total := 0
+		full := make(chan struct{})
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hook := r.URL.Query().Get("hook")
+
+			mu.Lock()
+			inflight[hook]++
+			if inflight[hook] > peak[hook] {
+				peak[hook] = inflight[hook]
+			}
+			if total++; total == 2*webhookParallelism {
+				close(full)
+			}
+			mu.Unlock()
+
+			select {
+			case <-full:
+			case <-time.After(time.Second):
+			}
+
+			mu.Lock()
+			inflight[hook]--
+			mu.Unlock()
+		}))
+		defer receiver.Close()
+
+		now := time.Now()
+		dls := []WebhookDelivery{}
+		for _, hook := range []string{"a", "b"} {
+			res := getResponse(t, srv, "POST", "/webhooks", strings.NewReader(`{"url":"`+receiver.URL+`?hook=`+hook+`","secret":"s3cret"}`))
+			assertResponseCode(t, res.StatusCode, http.StatusCreated)
+
+			var created Webhook
+			if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			for idx := 0; idx < 2*webhookParallelism; idx++ {
+				dls = append(dls, WebhookDelivery{WebhookID: created.ID, EventSeq: uint64(len(dls) + 1), EventType: eventCreated, Payload: "{}", NextAttempt: now})
+			}
+		}
+		if err := srv.store.EnqueueDeliveries(uint64(len(dls)), dls); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		srv.deliverWebhooks(now)
+
+		select {
+		case <-full:
+		default:
+			t.Error("expected webhooks to be delivered to concurrently")
+		}
+		for _, hook := range []string{"a", "b"} {
+			if peak[hook] != webhookParallelism {
+				t.Errorf("expected %d deliveries to %s in flight at most but got %d", webhookParallelism, hook, peak[hook])
+			}
+		}
+		if delivered, _ := srv.store.GetDeliveries(0, deliveryDelivered); len(*delivered) != len(dls) {
+			t.Errorf("expected %d deliveries to be delivered but got %d", len(dls), len(*delivered))
+		}
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	p := &WebhookPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
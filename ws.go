@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// wsMessage is single message exchanged over websocket in either direction
+type wsMessage struct {
+	Type    string            `json:"type"`
+	ID      string            `json:"id,omitempty"`
+	Names   []string          `json:"names,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"`
+	Since   *uint64           `json:"since,omitempty"`
+	Seq     uint64            `json:"seq,omitempty"`
+	Event   *ConfigEvent      `json:"event,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+const (
+	wsSubscribe    = "subscribe"
+	wsUnsubscribe  = "unsubscribe"
+	wsAck          = "ack"
+	wsSubscribed   = "subscribed"
+	wsUnsubscribed = "unsubscribed"
+	wsEvent        = "event"
+	wsError        = "error"
+)
+
+const (
+	wsMaxSubscriptions = 256
+	wsMaxMessageSize   = 1 << 20
+	wsWriteTimeout     = 10 * time.Second
+	wsPingInterval     = 30 * time.Second
+	wsPongTimeout      = 2 * wsPingInterval
+
+	// acked positions of subscriptions nobody resumed for that long are forgotten
+	defaultWsCursorTTL   = 7 * 24 * 60 * 60
+	wsCursorExpiryPeriod = time.Hour
+	wsCursorPrefix       = "ws:"
+)
+
+// errWsClosed stops replay to connection which is gone
+var errWsClosed = errors.New("websocket connection is closed")
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// wsSession serves single websocket connection carrying any number of subscriptions
+type wsSession struct {
+	srv  *WebServer
+	conn *websocket.Conn
+	out  chan wsMessage
+	done chan struct{}
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+
+	// caller the acked positions are stored for, they outlive the connection
+	owner string
+
+	// names of configs caller is allowed to read and to see secret values of
+	readable   func(name string) bool
+	revealable func(name string) bool
+}
+
+// wsGetHandler handles GET /ws
+func (srv *WebServer) wsGetHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// upgrader has responded already
+		return
+	}
+
+	session := &wsSession{
+		srv:        srv,
+		conn:       conn,
+		out:        make(chan wsMessage, subscriptionBacklog),
+		done:       make(chan struct{}),
+		subs:       map[string]*Subscription{},
+		owner:      subjectFrom(r),
+		readable:   srv.readable(r),
+		revealable: srv.revealable(r),
+	}
+	session.run()
+}
+
+// run pumps messages until either side closes the connection or server stops
+func (s *wsSession) run() {
+	defer s.conn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.writeLoop()
+	}()
+
+	s.readLoop()
+
+	close(s.done)
+	s.mu.Lock()
+	for id, sub := range s.subs {
+		sub.Close()
+		delete(s.subs, id)
+	}
+	s.mu.Unlock()
+	wg.Wait()
+}
+
+// readLoop handles client messages, returns once connection is gone
+func (s *wsSession) readLoop() {
+	s.conn.SetReadLimit(wsMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok {
+				s.srv.log.Debug("Websocket closed", zap.Error(err))
+			}
+			return
+		}
+
+		switch msg.Type {
+		case wsSubscribe:
+			s.subscribe(msg)
+		case wsUnsubscribe:
+			s.unsubscribe(msg.ID)
+			s.send(wsMessage{Type: wsUnsubscribed, ID: msg.ID})
+		case wsAck:
+			s.ack(msg.ID, msg.Seq)
+		default:
+			s.send(wsMessage{Type: wsError, ID: msg.ID, Error: fmt.Sprintf("unknown message type %q", msg.Type)})
+		}
+	}
+}
+
+// writeLoop is the only writer of the connection, it also keeps connection alive with pings
+func (s *wsSession) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-s.out:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				s.conn.Close()
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				s.conn.Close()
+				return
+			}
+		case <-s.srv.stopping:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is stopping")
+			s.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+			s.conn.Close()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send queues message for the writer, dropped once connection is gone
+func (s *wsSession) send(msg wsMessage) bool {
+	select {
+	case s.out <- msg:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// subscribe starts subscription, it resumes after since or after the last event caller has acked
+// for the same id in this or any earlier connection, replacing subscription with the same id
+func (s *wsSession) subscribe(msg wsMessage) {
+	if msg.ID == "" {
+		s.send(wsMessage{Type: wsError, Error: "subscription id is required"})
+		return
+	}
+
+	filter, err := wsMatcher(msg)
+	if err != nil {
+		s.send(wsMessage{Type: wsError, ID: msg.ID, Error: err.Error()})
+		return
+	}
+	match := func(event *ConfigEvent) bool {
+		return s.readable(event.Name) && filter(event)
+	}
+
+	s.mu.Lock()
+	if old, ok := s.subs[msg.ID]; ok {
+		old.Close()
+		delete(s.subs, msg.ID)
+	}
+	if len(s.subs) >= wsMaxSubscriptions {
+		s.mu.Unlock()
+		s.send(wsMessage{Type: wsError, ID: msg.ID, Error: fmt.Sprintf("connection is limited to %d subscriptions", wsMaxSubscriptions)})
+		return
+	}
+
+	var since uint64
+	switch acked, err := s.srv.store.GetChangeCursor(s.cursor(msg.ID)); {
+	case msg.Since != nil:
+		since = *msg.Since
+	case err == nil:
+		since = acked
+	case errors.Is(err, sql.ErrNoRows):
+		since = s.srv.events.Seq()
+	default:
+		s.mu.Unlock()
+		s.send(wsMessage{Type: wsError, ID: msg.ID, Error: err.Error()})
+		return
+	}
+
+	sub, replay, complete := s.srv.events.Subscribe(since, match)
+	s.subs[msg.ID] = sub
+	s.mu.Unlock()
+
+	s.send(wsMessage{Type: wsSubscribed, ID: msg.ID, Seq: s.srv.events.Seq()})
+
+	go s.forward(msg.ID, sub, since, match, replay, complete)
+}
+
+// forward relays replayed and live events of subscription to the writer, events the bus does not keep
+// anymore are replayed from the change log
+func (s *wsSession) forward(id string, sub *Subscription, since uint64, match func(*ConfigEvent) bool, replay []ConfigEvent, complete bool) {
+
+	// events replayed from the change log may arrive from the bus as well
+	last := since
+	send := func(event ConfigEvent) error {
+		if event.Seq <= last {
+			return nil
+		}
+		last = event.Seq
+		event = redactEvent(event, s.revealable)
+		if !s.send(wsMessage{Type: wsEvent, ID: id, Seq: event.Seq, Event: &event}) {
+			return errWsClosed
+		}
+		return nil
+	}
+
+	if complete {
+		for _, event := range replay {
+			if send(event) != nil {
+				return
+			}
+		}
+	} else if err := s.srv.replayChanges(since, match, send); err != nil {
+		if errors.Is(err, errWsClosed) {
+			return
+		}
+		s.srv.log.Info("Error replaying changes", zap.Error(err))
+		s.send(wsMessage{Type: eventReset, ID: id, Seq: s.srv.events.Seq()})
+	}
+
+	for event := range sub.C {
+		if send(event) != nil {
+			return
+		}
+	}
+
+	// channel is closed either by unsubscribe or because subscriber fell behind
+	if sub.Lost {
+		s.mu.Lock()
+		if s.subs[id] == sub {
+			delete(s.subs, id)
+		}
+		s.mu.Unlock()
+		s.send(wsMessage{Type: eventLost, ID: id, Seq: s.srv.events.Seq()})
+	}
+}
+
+// unsubscribe stops subscription, acked position is kept for resume until it expires
+func (s *wsSession) unsubscribe(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub, ok := s.subs[id]; ok {
+		sub.Close()
+		delete(s.subs, id)
+	}
+}
+
+// ack stores the last event client has processed for subscription so that it can resume after reconnect
+func (s *wsSession) ack(id string, seq uint64) {
+	if id == "" {
+		s.send(wsMessage{Type: wsError, Error: "subscription id is required"})
+		return
+	}
+	if err := s.srv.store.AdvanceChangeCursor(s.cursor(id), seq); err != nil {
+		s.send(wsMessage{Type: wsError, ID: id, Error: err.Error()})
+	}
+}
+
+// cursor names stored position of subscription, ids are scoped to the caller
+func (s *wsSession) cursor(id string) string {
+	return wsCursorPrefix + s.owner + ":" + id
+}
+
+// runWsCursorExpiry periodically forgets acked positions of subscriptions idle for longer than ttl, until ctx is done
+func (srv *WebServer) runWsCursorExpiry(ctx context.Context, ttl time.Duration) error {
+	ticker := time.NewTicker(wsCursorExpiryPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			srv.expireWsCursors(now, ttl)
+		}
+	}
+}
+
+// expireWsCursors forgets acked positions not advanced within ttl before now and logs the outcome
+func (srv *WebServer) expireWsCursors(now time.Time, ttl time.Duration) {
+	n, err := srv.store.ExpireChangeCursors(wsCursorPrefix, now.Add(-ttl))
+	if err != nil {
+		srv.log.Info("Error expiring websocket cursors", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		srv.log.Info("Websocket cursors expired", zap.Int("count", n))
+	}
+}
+
+// wsMatcher builds event matcher from subscription names and search filters, both have to match
+func wsMatcher(msg wsMessage) (func(*ConfigEvent) bool, error) {
+	query := url.Values{}
+	for k, v := range msg.Filters {
+		query.Set(k, v)
+	}
+	filters, err := parseSearchFilters(query)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, name := range msg.Names {
+		names[name] = true
+	}
+
+	return func(event *ConfigEvent) bool {
+		if len(names) > 0 && !names[event.Name] {
+			return false
+		}
+		if len(filters) > 0 {
+			return event.Config != nil && matchMetadata(event.Config.Metadata, filters)
+		}
+		return true
+	}, nil
+}
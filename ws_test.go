@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+
+	t.Run("valid", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil)
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer conn.Close()
+
+		write := func(msg wsMessage) {
+			t.Helper()
+			if err := conn.WriteJSON(msg); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+		read := func() wsMessage {
+			t.Helper()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			return msg
+		}
+		post := func(body string) {
+			t.Helper()
+			res, err := http.Post(ts.URL+"/configs", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			res.Body.Close()
+		}
+
+		write(wsMessage{Type: wsSubscribe, ID: "names", Names: []string{"abc"}})
+		if msg := read(); msg.Type != wsSubscribed || msg.ID != "names" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+
+		post(`{"name":"other","metadata":{}}`)
+		post(`{"name":"abc","metadata":{"v":"1"}}`)
+
+		msg := read()
+		if msg.Type != wsEvent || msg.ID != "names" || msg.Event.Name != "abc" || msg.Seq != 2 {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+
+		// events after the acked one are replayed on resubscribe
+		write(wsMessage{Type: wsAck, ID: "names", Seq: msg.Seq})
+		write(wsMessage{Type: wsUnsubscribe, ID: "names"})
+		if msg := read(); msg.Type != wsUnsubscribed {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/configs/abc", strings.NewReader(`{"metadata":{"v":"2"}}`))
+		patch, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		patch.Body.Close()
+
+		write(wsMessage{Type: wsSubscribe, ID: "names", Names: []string{"abc"}})
+		if msg := read(); msg.Type != wsSubscribed {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		if msg := read(); msg.Type != wsEvent || msg.Seq != 3 || msg.Event.Type != eventUpdated {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+
+		write(wsMessage{Type: "bogus"})
+		if msg := read(); msg.Type != wsError {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	})
+
+	t.Run("resume after reconnect", func(t *testing.T) {
+		srv, _ := newTestServer(t, nil)
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		dial := func() *websocket.Conn {
+			t.Helper()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			return conn
+		}
+		exchange := func(conn *websocket.Conn, msg wsMessage) wsMessage {
+			t.Helper()
+			if err := conn.WriteJSON(msg); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var reply wsMessage
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			return reply
+		}
+
+		conn := dial()
+		if msg := exchange(conn, wsMessage{Type: wsSubscribe, ID: "abc", Names: []string{"abc"}}); msg.Type != wsSubscribed {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		res := getResponse(t, srv, "POST", "/configs", strings.NewReader(`{"name":"abc","metadata":{"v":"1"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != wsEvent || msg.Seq != 1 {
+			t.Fatalf("unexpected message %+v: %v", msg, err)
+		}
+
+		// ack is handled before the following unsubscribe is answered
+		if err := conn.WriteJSON(wsMessage{Type: wsAck, ID: "abc", Seq: msg.Seq}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if msg := exchange(conn, wsMessage{Type: wsUnsubscribe, ID: "abc"}); msg.Type != wsUnsubscribed {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		conn.Close()
+
+		res = getResponse(t, srv, "PATCH", "/configs/abc", strings.NewReader(`{"metadata":{"v":"2"}}`))
+		assertResponseCode(t, res.StatusCode, http.StatusOK)
+
+		conn = dial()
+		defer conn.Close()
+
+		if msg := exchange(conn, wsMessage{Type: wsSubscribe, ID: "abc", Names: []string{"abc"}}); msg.Type != wsSubscribed {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != wsEvent || msg.Seq != 2 || msg.Event.Type != eventUpdated {
+			t.Fatalf("expected event after the acked one to be replayed but got %+v: %v", msg, err)
+		}
+	})
+
+	t.Run("resume after restart", func(t *testing.T) {
+
+		// acked before restart, the following change is in the change log only
+		srv, _ := newTestServer(t, nil, func(db *Database) error {
+			if _, err := db.InsertConfig(&Config{Name: "abc", Metadata: &Metadata{"v": "1"}}); err != nil {
+				return err
+			}
+			if err := db.UpdateConfigByName("abc", &Config{Metadata: &Metadata{"v": "2"}}); err != nil {
+				return err
+			}
+			return db.AdvanceChangeCursor("ws:"+anonymousPrincipal+":abc", 1)
+		})
+
+		ts := httptest.NewServer(srv.Handler)
+		defer ts.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		defer conn.Close()
+
+		if err := conn.WriteJSON(wsMessage{Type: wsSubscribe, ID: "abc", Names: []string{"abc"}}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for _, want := range []string{wsSubscribed, wsEvent} {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil || msg.Type != want {
+				t.Fatalf("expected %s but got %+v: %v", want, msg, err)
+			}
+			if want == wsEvent && (msg.Seq != 2 || msg.Event.Type != eventUpdated) {
+				t.Errorf("expected event after the acked one to be replayed but got %+v", msg)
+			}
+		}
+	})
+
+	t.Run("idle cursors expire", func(t *testing.T) {
+		srv, db := newTestServer(t, nil, func(db *Database) error {
+			for _, consumer := range []string{"ws:x:idle", "ws:x:active", webhookCursor} {
+				if err := db.AdvanceChangeCursor(consumer, 1); err != nil {
+					return err
+				}
+			}
+			_, err := db.writer.Exec(`UPDATE change_cursors SET updated_at = datetime('now', '-2 days') WHERE consumer IN (?, ?)`, "ws:x:idle", webhookCursor)
+			return err
+		})
+
+		srv.expireWsCursors(time.Now(), 24*time.Hour)
+
+		if _, err := db.GetChangeCursor("ws:x:idle"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected idle cursor to be forgotten but got %v", err)
+		}
+		for _, consumer := range []string{"ws:x:active", webhookCursor} {
+			if _, err := db.GetChangeCursor(consumer); err != nil {
+				t.Errorf("expected %s cursor to be kept but got %v", consumer, err)
+			}
+		}
+	})
+
+}